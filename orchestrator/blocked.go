@@ -0,0 +1,165 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tparlmer/leopold/protocol"
+)
+
+// BlockedAction is what the orchestrator should do about a blocked agent.
+type BlockedAction int
+
+const (
+	// BlockedFail kills the agent and fails the task.
+	BlockedFail BlockedAction = iota
+	// BlockedAnswer sends Response back to the agent in an AnswerMessage.
+	BlockedAnswer
+	// BlockedDefer means "no answer yet": the agent is kept waiting and
+	// the handler is asked again after RetryAfter, or
+	// DefaultBlockedRetryAfter if that isn't positive.
+	BlockedDefer
+	// BlockedCancel sends the agent a CancelMessage, exactly as if the
+	// task's context had been cancelled.
+	BlockedCancel
+)
+
+// DefaultBlockedRetryAfter is how long a BlockedDefer without a
+// RetryAfter waits before asking the handler again.
+const DefaultBlockedRetryAfter = time.Second
+
+// BlockedDecision is a BlockedHandler's verdict on one question.
+type BlockedDecision struct {
+	Action     BlockedAction
+	Response   string        // answer text, for BlockedAnswer
+	RetryAfter time.Duration // delay before asking again, for BlockedDefer
+	Reason     string        // why, for BlockedFail and BlockedCancel
+}
+
+// BlockedHandler decides what happens when an agent sends a BlockedMessage.
+//
+// HandleBlocked runs on its own goroutine and may take as long as it
+// likes: the heartbeat watchdog is paused until it returns, since a
+// blocked agent is not expected to make progress. ctx is cancelled if
+// the task ends for some other reason in the meantime.
+type BlockedHandler interface {
+	HandleBlocked(ctx context.Context, taskID string, msg *protocol.BlockedMessage) BlockedDecision
+}
+
+// BlockedHandlerFunc adapts a plain function to BlockedHandler.
+type BlockedHandlerFunc func(ctx context.Context, taskID string, msg *protocol.BlockedMessage) BlockedDecision
+
+// HandleBlocked calls f.
+func (f BlockedHandlerFunc) HandleBlocked(ctx context.Context, taskID string, msg *protocol.BlockedMessage) BlockedDecision {
+	return f(ctx, taskID, msg)
+}
+
+// FailOnBlocked is the default policy: a blocked agent fails its task.
+var FailOnBlocked BlockedHandler = BlockedHandlerFunc(
+	func(ctx context.Context, taskID string, msg *protocol.BlockedMessage) BlockedDecision {
+		return BlockedDecision{Action: BlockedFail, Reason: "no handler for blocked agents"}
+	},
+)
+
+// AutoPick answers every question with Options[n]. Questions that don't
+// offer an option n fail the task.
+func AutoPick(n int) BlockedHandler {
+	return BlockedHandlerFunc(func(ctx context.Context, taskID string, msg *protocol.BlockedMessage) BlockedDecision {
+		if n < 0 || n >= len(msg.Options) {
+			return BlockedDecision{
+				Action: BlockedFail,
+				Reason: fmt.Sprintf("cannot auto-pick option %d of %d", n, len(msg.Options)),
+			}
+		}
+		return BlockedDecision{Action: BlockedAnswer, Response: msg.Options[n]}
+	})
+}
+
+// Question is a blocked agent's question waiting for a human. Exactly
+// one of Answer, Cancel or Fail should be called; later calls are
+// ignored.
+type Question struct {
+	TaskID   string
+	Question string
+	Options  []string
+	Asked    time.Time
+
+	reply chan BlockedDecision
+}
+
+// Answer sends response to the agent.
+func (q *Question) Answer(response string) {
+	q.decide(BlockedDecision{Action: BlockedAnswer, Response: response})
+}
+
+// Cancel asks the agent to stop gracefully.
+func (q *Question) Cancel(reason string) {
+	q.decide(BlockedDecision{Action: BlockedCancel, Reason: reason})
+}
+
+// Fail kills the agent and fails the task.
+func (q *Question) Fail(reason string) {
+	q.decide(BlockedDecision{Action: BlockedFail, Reason: reason})
+}
+
+func (q *Question) decide(d BlockedDecision) {
+	select {
+	case q.reply <- d:
+	default:
+	}
+}
+
+// QueueForHuman delivers every question on queue and waits for someone
+// to decide it. The agent is kept alive for as long as that takes.
+func QueueForHuman(queue chan<- *Question) BlockedHandler {
+	return BlockedHandlerFunc(func(ctx context.Context, taskID string, msg *protocol.BlockedMessage) BlockedDecision {
+		q := &Question{
+			TaskID:   taskID,
+			Question: msg.Question,
+			Options:  msg.Options,
+			Asked:    time.Now(),
+			reply:    make(chan BlockedDecision, 1),
+		}
+
+		select {
+		case queue <- q:
+		case <-ctx.Done():
+			return BlockedDecision{Action: BlockedFail, Reason: ctx.Err().Error()}
+		}
+
+		select {
+		case d := <-q.reply:
+			return d
+		case <-ctx.Done():
+			return BlockedDecision{Action: BlockedFail, Reason: ctx.Err().Error()}
+		}
+	})
+}
+
+// askBlocked runs h on its own goroutine, honouring BlockedDefer, and
+// delivers the final decision on the returned channel.
+func askBlocked(ctx context.Context, h BlockedHandler, taskID string, msg *protocol.BlockedMessage) <-chan BlockedDecision {
+	ch := make(chan BlockedDecision, 1)
+	go func() {
+		for {
+			d := h.HandleBlocked(ctx, taskID, msg)
+			if d.Action != BlockedDefer {
+				ch <- d
+				return
+			}
+			if d.RetryAfter <= 0 {
+				d.RetryAfter = DefaultBlockedRetryAfter
+			}
+			timer := time.NewTimer(d.RetryAfter)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				ch <- BlockedDecision{Action: BlockedFail, Reason: ctx.Err().Error()}
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,96 @@
+package orchestrator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tparlmer/leopold/protocol"
+)
+
+// Budget names one of the limits a task can run out of.
+type Budget string
+
+const (
+	BudgetDuration  Budget = "duration"   // Config.MaxDuration, in seconds
+	BudgetTokensIn  Budget = "tokens_in"  // Config.MaxTokensIn
+	BudgetTokensOut Budget = "tokens_out" // Config.MaxTokensOut
+	BudgetTokens    Budget = "tokens"     // Config.MaxTokens, input and output combined
+
+	// Going over these two kills the agent with ErrCostExceeded and
+	// ErrRSSExceeded rather than a BudgetError, so they only turn up
+	// in BudgetWarning events.
+	BudgetCost Budget = "cost_usd" // Config.MaxCostUSD
+	BudgetRSS  Budget = "rss_mb"   // Config.MaxRSSMB
+)
+
+// DefaultBudgetWarning is how much of a budget a task uses before a
+// BudgetWarning, when Config.BudgetWarning is zero.
+const DefaultBudgetWarning = 0.8
+
+// BudgetError reports which budget a task went over, and by how much.
+type BudgetError struct {
+	Budget Budget
+	Used   float64
+	Limit  float64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s: %s used %g, limit %g", ErrBudgetExceeded, e.Budget, e.Used, e.Limit)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// checkBudgets compares the counters in a heartbeat against the
+// configured budgets and returns the first one exceeded, if any. The
+// agent's elapsed time is checked too: the orchestrator's own deadline
+// catches agents that stop reporting, this catches agents whose clock
+// started before ours.
+func (o *Orchestrator) checkBudgets(hb *protocol.HeartbeatMessage) *BudgetError {
+	for _, u := range o.heartbeatUsage(hb) {
+		if u.limit > 0 && u.used > u.limit {
+			return &BudgetError{Budget: u.budget, Used: u.used, Limit: u.limit}
+		}
+	}
+	return nil
+}
+
+// budgetUsage is how much of one budget a task has used.
+type budgetUsage struct {
+	budget Budget
+	used   float64
+	limit  float64 // 0 = unlimited
+}
+
+// heartbeatUsage is what a heartbeat says about the budgets enforced
+// with BudgetErrors.
+func (o *Orchestrator) heartbeatUsage(hb *protocol.HeartbeatMessage) []budgetUsage {
+	return []budgetUsage{
+		{BudgetTokensIn, float64(hb.TokensIn), float64(o.config.MaxTokensIn)},
+		{BudgetTokensOut, float64(hb.TokensOut), float64(o.config.MaxTokensOut)},
+		{BudgetTokens, float64(hb.TokensIn + hb.TokensOut), float64(o.config.MaxTokens)},
+		{BudgetDuration, hb.ElapsedS, o.config.MaxDuration.Seconds()},
+	}
+}
+
+// warnBudgets emits a BudgetWarning for each budget in usage past
+// Config.BudgetWarning, unless warned already has it.
+func (o *Orchestrator) warnBudgets(task Task, warned map[Budget]bool, usage ...budgetUsage) {
+	for _, u := range usage {
+		if u.limit <= 0 || warned[u.budget] || u.used < o.config.BudgetWarning*u.limit {
+			continue
+		}
+		warned[u.budget] = true
+		o.emit(BudgetWarning{EventHeader: eventHeader(task), Budget: u.budget, Used: u.used, Limit: u.limit})
+	}
+}
+
+// durationError is the BudgetError for a task that ran out of time.
+func (o *Orchestrator) durationError(elapsed time.Duration) *BudgetError {
+	return &BudgetError{
+		Budget: BudgetDuration,
+		Used:   elapsed.Seconds(),
+		Limit:  o.config.MaxDuration.Seconds(),
+	}
+}
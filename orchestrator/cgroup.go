@@ -0,0 +1,42 @@
+package orchestrator
+
+import "os/exec"
+
+// CgroupConfig places every agent in its own cgroup v2 with hard
+// resource limits, so the kernel enforces them instead of the
+// orchestrator reacting to heartbeats after the fact.
+//
+// Parent must be a cgroup v2 directory the orchestrator may create
+// sub-cgroups in (for example one delegated by systemd), and must not
+// contain processes itself. If cgroups aren't usable, agents run without
+// them, exactly as if Config.Cgroup were nil.
+type CgroupConfig struct {
+	Parent      string  // cgroup v2 directory to create agent cgroups under
+	MemoryMaxMB int     // memory.max (0 = unlimited)
+	CPUs        float64 // cpu.max as a number of CPUs, e.g. 1.5 (0 = unlimited)
+	PidsMax     int     // pids.max (0 = unlimited)
+}
+
+// startInCgroup starts the command newCmd makes inside cg. Where the
+// kernel allows (clone3, Linux 5.7+) the agent is spawned there
+// directly, so not even its first allocations or forks escape the
+// limits. Failing that it's moved in right after starting, and
+// anything it forked in between stays outside. Reports whether the
+// agent made it into cg at all.
+func startInCgroup(newCmd func() *exec.Cmd, cg *cgroup) (*agentProc, bool, error) {
+	cmd := newCmd()
+	if dir, err := cg.spawnInto(cmd); err == nil {
+		proc, err := startProc(cmd)
+		dir.Close()
+		if err == nil {
+			return proc, true, nil
+		}
+		// A Cmd only starts once; try again without the cgroup
+		cmd = newCmd()
+	}
+	proc, err := startProc(cmd)
+	if err != nil {
+		return nil, false, err
+	}
+	return proc, cg.add(proc.cmd.Process.Pid) == nil, nil
+}
@@ -0,0 +1,141 @@
+package orchestrator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cpuPeriodUS is the cpu.max period. Quotas are scaled against it.
+const cpuPeriodUS = 100000
+
+// cgroupSeq keeps cgroup names unique when a task ID is reused.
+var cgroupSeq atomic.Int64
+
+// cgroup is one agent's cgroup v2 directory.
+type cgroup struct {
+	path string
+}
+
+// newCgroup creates a cgroup for taskID under cfg.Parent and applies
+// cfg's limits to it.
+func newCgroup(cfg *CgroupConfig, taskID string) (*cgroup, error) {
+	if _, err := os.Stat(filepath.Join(cfg.Parent, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 directory: %w", cfg.Parent, err)
+	}
+
+	// Make sure the controllers we need reach our children. Already
+	// enabled is fine; anything else shows up when writing limits.
+	writeCgroupFile(cfg.Parent, "cgroup.subtree_control", "+memory +cpu +pids")
+
+	name := fmt.Sprintf("leopold-%s-%d", safeName(taskID), cgroupSeq.Add(1))
+	cg := &cgroup{path: filepath.Join(cfg.Parent, name)}
+	if err := os.Mkdir(cg.path, 0o755); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+
+	limits := []struct {
+		file  string
+		value string
+		set   bool
+	}{
+		{"memory.max", strconv.FormatInt(int64(cfg.MemoryMaxMB)*1024*1024, 10), cfg.MemoryMaxMB > 0},
+		{"cpu.max", fmt.Sprintf("%d %d", int64(cfg.CPUs*cpuPeriodUS), cpuPeriodUS), cfg.CPUs > 0},
+		{"pids.max", strconv.Itoa(cfg.PidsMax), cfg.PidsMax > 0},
+	}
+	for _, l := range limits {
+		if !l.set {
+			continue
+		}
+		if err := writeCgroupFile(cg.path, l.file, l.value); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("set %s: %w", l.file, err)
+		}
+	}
+	return cg, nil
+}
+
+// spawnInto makes cmd start inside the cgroup rather than be moved in
+// once running. The returned directory must stay open until cmd has
+// started.
+func (cg *cgroup) spawnInto(cmd *exec.Cmd) (*os.File, error) {
+	dir, err := os.Open(cg.path)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return dir, nil
+}
+
+// add moves a process into the cgroup.
+func (cg *cgroup) add(pid int) error {
+	return writeCgroupFile(cg.path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// procs lists the processes in the cgroup.
+func (cg *cgroup) procs() ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// oomKilled reports whether the kernel OOM-killed anything in the
+// cgroup for exceeding memory.max.
+func (cg *cgroup) oomKilled() bool {
+	f, err := os.Open(filepath.Join(cg.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n > 0
+		}
+	}
+	return false
+}
+
+// remove kills anything left in the cgroup and deletes it.
+func (cg *cgroup) remove() error {
+	// cgroup.kill needs Linux 5.14; without it, stragglers make
+	// the rmdir below fail and the cgroup is left behind.
+	writeCgroupFile(cg.path, "cgroup.kill", "1")
+
+	var err error
+	for range 50 {
+		if err = os.Remove(cg.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond) // killed processes take a moment to leave
+	}
+	return fmt.Errorf("remove cgroup: %w", err)
+}
+
+// writeCgroupFile writes a single value to a cgroup interface file.
+func writeCgroupFile(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0)
+}
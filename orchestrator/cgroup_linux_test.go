@@ -0,0 +1,81 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCgroupParent returns a delegated cgroup v2 directory to run
+// cgroup tests in, set via LEOPOLD_TEST_CGROUP, or skips the test.
+func testCgroupParent(t *testing.T) string {
+	t.Helper()
+	parent := os.Getenv("LEOPOLD_TEST_CGROUP")
+	if parent == "" {
+		t.Skip("set LEOPOLD_TEST_CGROUP to a delegated cgroup v2 directory to run cgroup tests")
+	}
+	return parent
+}
+
+func TestCgroupFallsBackWhenUnavailable(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("happy"),
+		HeartbeatTimeout: 5 * time.Second,
+		Cgroup: &CgroupConfig{Parent: t.TempDir(), MemoryMaxMB: 64},
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-14", Prompt: "do the thing", Repo: t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Cgroup != "" {
+		t.Errorf("Cgroup = %q, want none outside cgroup v2", res.Cgroup)
+	}
+}
+
+func TestCgroupAppliesLimits(t *testing.T) {
+	parent := testCgroupParent(t)
+	cg, err := newCgroup(&CgroupConfig{Parent: parent, MemoryMaxMB: 64, CPUs: 1.5, PidsMax: 32}, "task/with spaces")
+	if err != nil {
+		t.Fatalf("newCgroup: %v", err)
+	}
+	defer cg.remove()
+
+	if strings.ContainsAny(filepath.Base(cg.path), "/ ") {
+		t.Errorf("cgroup name %q not sanitized", filepath.Base(cg.path))
+	}
+	want := map[string]string{
+		"memory.max": "67108864",
+		"cpu.max":    "150000 100000",
+		"pids.max":   "32",
+	}
+	for file, value := range want {
+		data, err := os.ReadFile(filepath.Join(cg.path, file))
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		if got := strings.TrimSpace(string(data)); got != value {
+			t.Errorf("%s = %q, want %q", file, got, value)
+		}
+	}
+}
+
+func TestCgroupReportsOOMKill(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("liar"),
+		HeartbeatTimeout: 10 * time.Second,
+		Cgroup: &CgroupConfig{Parent: testCgroupParent(t), MemoryMaxMB: 32},
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-15", Prompt: "do the thing", Repo: t.TempDir()})
+	if !errors.Is(err, ErrOOMKilled) {
+		t.Fatalf("err = %v, want ErrOOMKilled", err)
+	}
+	if _, statErr := os.Stat(res.Cgroup); !os.IsNotExist(statErr) {
+		t.Errorf("cgroup %s left behind", res.Cgroup)
+	}
+}
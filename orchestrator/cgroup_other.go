@@ -0,0 +1,27 @@
+//go:build !linux
+
+package orchestrator
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// cgroup is a stub: cgroups only exist on Linux.
+type cgroup struct {
+	path string
+}
+
+func newCgroup(cfg *CgroupConfig, taskID string) (*cgroup, error) {
+	return nil, errors.New("cgroups need Linux")
+}
+
+func (cg *cgroup) spawnInto(cmd *exec.Cmd) (*os.File, error) {
+	return nil, errors.New("cgroups need Linux")
+}
+
+func (cg *cgroup) add(pid int) error     { return nil }
+func (cg *cgroup) procs() ([]int, error) { return nil, nil }
+func (cg *cgroup) oomKilled() bool       { return false }
+func (cg *cgroup) remove() error         { return nil }
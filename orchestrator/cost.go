@@ -0,0 +1,35 @@
+package orchestrator
+
+import "fmt"
+
+// Price is what a model charges, in US dollars per token.
+type Price struct {
+	InputUSD  float64 // per input token
+	OutputUSD float64 // per output token
+}
+
+// cost is what a task has spent so far. The agent's own figure wins when
+// it reports one - it may know about caching discounts and the like -
+// and otherwise the token counters are priced with Config.Pricing.
+// Pricing[""] applies when the agent doesn't name its model. Without
+// either, the cost is unknown and reported as 0.
+func (o *Orchestrator) cost(model string, tokensIn, tokensOut int, reportedUSD float64) float64 {
+	if reportedUSD > 0 {
+		return reportedUSD
+	}
+	price, ok := o.config.Pricing[model]
+	if !ok {
+		return 0
+	}
+	return float64(tokensIn)*price.InputUSD + float64(tokensOut)*price.OutputUSD
+}
+
+// overCost reports whether usd is over the cost budget.
+func (o *Orchestrator) overCost(usd float64) bool {
+	return o.config.MaxCostUSD > 0 && usd > o.config.MaxCostUSD
+}
+
+// costError describes a cost kill.
+func (o *Orchestrator) costError(res *TaskResult) error {
+	return fmt.Errorf("%w: $%.4f spent, limit $%.4f", ErrCostExceeded, res.CostUSD, o.config.MaxCostUSD)
+}
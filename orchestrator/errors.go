@@ -0,0 +1,72 @@
+package orchestrator
+
+import "errors"
+
+// Errors returned by Run and friends. Each way a task can fail has a
+// sentinel here that the returned error wraps, so callers can decide
+// what to do with errors.Is instead of matching messages. The details -
+// limits, exit status, the agent's stderr - are in the wrapping error
+// and the TaskResult.
+
+// ErrCancelled is returned when a task was stopped because its context
+// was cancelled. If the agent acknowledged the CancelMessage in time,
+// its "cancelled" CompleteMessage is returned alongside the error.
+var ErrCancelled = errors.New("task cancelled")
+
+// ErrHeartbeatTimeout is returned when the agent went silent for longer
+// than Config.HeartbeatTimeout, or never said hello during a handshake.
+var ErrHeartbeatTimeout = errors.New("agent heartbeat timeout")
+
+// ErrRSSExceeded is returned when the agent went over Config.MaxRSSMB,
+// by our measurement or its own.
+var ErrRSSExceeded = errors.New("agent exceeded RSS limit")
+
+// ErrCostExceeded is returned when the agent went over
+// Config.MaxCostUSD.
+var ErrCostExceeded = errors.New("agent exceeded cost limit")
+
+// ErrProtocol is returned when the agent sent something that isn't a
+// valid message, or speaks a protocol version we don't.
+var ErrProtocol = errors.New("agent protocol error")
+
+// ErrCrashed is returned when the agent exited with a failure status
+// before completing.
+var ErrCrashed = errors.New("agent crashed")
+
+// ErrOOMKilled is returned, along with ErrCrashed, when the kernel
+// killed the agent for going over its cgroup's memory.max.
+var ErrOOMKilled = errors.New("agent OOM-killed by cgroup memory limit")
+
+// ErrVerifyFailed is returned when the agent completed but the
+// verification command still failed after Config.Verify.MaxAttempts.
+var ErrVerifyFailed = errors.New("verification failed")
+
+// ErrDisallowedPath is returned when the agent changed files outside
+// Config.AllowedPaths.
+var ErrDisallowedPath = errors.New("agent changed files outside allowed paths")
+
+// ErrBudgetExceeded is wrapped by the *BudgetError for a task that went
+// over its token or wall-clock budget. Those tasks are cancelled rather
+// than killed outright, so the error wraps ErrCancelled too.
+var ErrBudgetExceeded = errors.New("task budget exceeded")
+
+// ErrBlocked is returned when the agent asked a question and the
+// BlockedHandler failed the task. A handler that cancels instead
+// produces ErrCancelled with ErrBlocked as the cause.
+var ErrBlocked = errors.New("agent blocked")
+
+// ErrExitedWithoutComplete is returned when the agent exited cleanly
+// without sending a CompleteMessage.
+var ErrExitedWithoutComplete = errors.New("agent exited without completing")
+
+// ErrPoolClosed is returned by Pool.Submit after Shutdown, and by the
+// Futures of tasks that were still queued when it was called.
+var ErrPoolClosed = errors.New("pool closed")
+
+// ErrCycle is returned by RunGraph for tasks that depend on each other
+// in a circle.
+var ErrCycle = errors.New("dependency cycle")
+
+// ErrUpstreamFailed is the error for a graph task that was skipped
+// because a task it depends on, directly or not, failed.
+var ErrUpstreamFailed = errors.New("upstream task failed")
@@ -0,0 +1,175 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tparlmer/leopold/protocol"
+)
+
+// Event is something that happened during a task, passed to
+// Config.Observer and sent on Config.Events as it happens. Every event
+// is one of the structs below - the unexported method seals the
+// interface, so a type switch over them is exhaustive.
+//
+// A task's events come in order: TaskStarted, then what the agent
+// sent and what the orchestrator did about it, then Exited, once per
+// agent spawned (retries under Config.Verify spawn more), and finally
+// Completed.
+type Event interface {
+	EventTask() string
+	EventTime() time.Time
+
+	event()
+}
+
+// EventHeader is what every event carries: which task it's about and
+// when it happened.
+type EventHeader struct {
+	TaskID string
+	Time   time.Time
+}
+
+func (h EventHeader) EventTask() string    { return h.TaskID }
+func (h EventHeader) EventTime() time.Time { return h.Time }
+
+// Observer is told about every event as it happens. Observe is called
+// from the goroutine supervising the task, so it must return quickly,
+// and from several at once when tasks run in a Pool.
+type Observer interface {
+	Observe(Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(Event)
+
+func (f ObserverFunc) Observe(ev Event) { f(ev) }
+
+// TaskStarted is an agent spawned for the task.
+type TaskStarted struct {
+	EventHeader
+	Task Task
+	PID  int
+}
+
+// MessageReceived is any message from the agent. Heartbeats and
+// questions get their own events too.
+type MessageReceived struct {
+	EventHeader
+	Message protocol.Message
+}
+
+// Blocked is the agent asking a question, handed to Config.Blocked to
+// decide.
+type Blocked struct {
+	EventHeader
+	Question string
+	Options  []string
+}
+
+// BudgetWarning is a budget running low: the task has used
+// Config.BudgetWarning of it or more. Sent once per budget per agent.
+type BudgetWarning struct {
+	EventHeader
+	Budget Budget
+	Used   float64
+	Limit  float64
+}
+
+// Killed is the orchestrator signalling the agent's process group,
+// rather than letting it wrap up. Reason is the error the task fails
+// with.
+type Killed struct {
+	EventHeader
+	Reason error
+}
+
+// Exited is the agent's process exiting, for whatever reason.
+type Exited struct {
+	EventHeader
+	ExitCode int       // -1 if killed by a signal
+	Signal   os.Signal // the signal that killed it (nil if it exited by itself)
+	WallTime time.Duration
+}
+
+// Completed is Run returning, however the task went.
+type Completed struct {
+	EventHeader
+	Result *TaskResult
+	Err    error
+}
+
+// FileOp is what happened to a file.
+type FileOp int
+
+const (
+	FileCreated FileOp = iota + 1 // created, or moved in
+	FileWritten                   // written to
+	FileRemoved                   // deleted, or moved out
+)
+
+func (op FileOp) String() string {
+	switch op {
+	case FileCreated:
+		return "created"
+	case FileWritten:
+		return "written"
+	case FileRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("FileOp(%d)", int(op))
+	}
+}
+
+// FileChanged is a change the orchestrator saw the agent make to a file
+// in its repo (only with Config.WatchFiles, on Linux). A file written
+// in several goes shows up several times.
+type FileChanged struct {
+	EventHeader
+	Path string // slash-separated, relative to Task.Repo
+	Op   FileOp
+}
+
+// HeartbeatReceived is a heartbeat from the agent: its progress report,
+// with the orchestrator's own view of what it has been editing.
+type HeartbeatReceived struct {
+	EventHeader
+	Heartbeat *protocol.HeartbeatMessage
+	// FilesTouched is every file the orchestrator saw change since the
+	// previous heartbeat, sorted. Unlike Heartbeat.FilesTouched it
+	// doesn't rely on the agent, but it's only filled in with
+	// Config.WatchFiles, on Linux.
+	FilesTouched []string
+}
+
+func (TaskStarted) event()       {}
+func (MessageReceived) event()   {}
+func (HeartbeatReceived) event() {}
+func (Blocked) event()           {}
+func (BudgetWarning) event()     {}
+func (FileChanged) event()       {}
+func (Killed) event()            {}
+func (Exited) event()            {}
+func (Completed) event()         {}
+
+// eventHeader starts an event about task, happening now.
+func eventHeader(task Task) EventHeader {
+	return EventHeader{TaskID: task.ID, Time: time.Now()}
+}
+
+// emit passes ev to Config.Observer, then sends it on Config.Events
+// without blocking: like signal.Notify, it drops events the receiver
+// has no room for, so a slow receiver can't stall supervision.
+func (o *Orchestrator) emit(ev Event) {
+	if o.config.Observer != nil {
+		o.config.Observer.Observe(ev)
+	}
+	if o.config.Events == nil {
+		return
+	}
+	select {
+	case o.config.Events <- ev:
+	default:
+	}
+}
@@ -0,0 +1,186 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchFilesReportsEdits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("watching files needs inotify")
+	}
+	events := make(chan Event, 1024)
+	orch := New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+		CancelGrace: 100 * time.Millisecond,
+		TermGrace: 100 * time.Millisecond,
+		WatchFiles: true,
+		Events: events,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := orch.Run(ctx, Task{ID: "watch-1", Prompt: "write notes.txt, then hang", Repo: t.TempDir()})
+		errCh <- err
+	}()
+
+	var changed, touched bool
+	timeout := time.After(5 * time.Second)
+	for !changed || !touched {
+		select {
+		case ev := <-events:
+			if ev.EventTask() != "watch-1" || ev.EventTime().IsZero() {
+				t.Errorf("event header = %q at %v", ev.EventTask(), ev.EventTime())
+			}
+			switch ev := ev.(type) {
+			case FileChanged:
+				if ev.Path != "notes.txt" {
+					t.Errorf("changed %s, want notes.txt", ev.Path)
+				}
+				changed = true
+			case HeartbeatReceived:
+				if ev.Heartbeat == nil {
+					t.Error("heartbeat event without the heartbeat")
+				}
+				touched = touched || slices.Equal(ev.FilesTouched, []string{"notes.txt"})
+			}
+		case <-timeout:
+			t.Fatalf("changed: %v, touched: %v", changed, touched)
+		}
+	}
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want ErrCancelled", err)
+	}
+}
+
+// recorder is an Observer that keeps every event.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Observe(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// kinds lists the recorded events' types, e.g. "orchestrator.Exited".
+func (r *recorder) kinds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []string
+	for _, ev := range r.events {
+		kinds = append(kinds, fmt.Sprintf("%T", ev))
+	}
+	return kinds
+}
+
+func TestObserverSeesLifecycle(t *testing.T) {
+	rec := &recorder{}
+	orch := New(Config{
+		AgentBin: agentBin("happy"),
+		HeartbeatTimeout: 5 * time.Second,
+		Observer: rec,
+	})
+
+	if _, err := orch.Run(context.Background(), Task{ID: "observed", Repo: t.TempDir()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"orchestrator.TaskStarted",
+		"orchestrator.MessageReceived", "orchestrator.HeartbeatReceived",
+		"orchestrator.MessageReceived", "orchestrator.HeartbeatReceived",
+		"orchestrator.MessageReceived",
+		"orchestrator.Exited",
+		"orchestrator.Completed",
+	}
+	if got := rec.kinds(); !slices.Equal(got, want) {
+		t.Fatalf("events:\n%v\nwant:\n%v", got, want)
+	}
+	var last time.Time
+	for _, ev := range rec.events {
+		if ev.EventTask() != "observed" {
+			t.Errorf("%T is about task %q", ev, ev.EventTask())
+		}
+		if ev.EventTime().Before(last) {
+			t.Errorf("%T at %v, before the event ahead of it", ev, ev.EventTime())
+		}
+		last = ev.EventTime()
+	}
+	if started := rec.events[0].(TaskStarted); started.PID == 0 {
+		t.Error("TaskStarted without a PID")
+	}
+	if done := rec.events[len(rec.events)-1].(Completed); done.Result == nil || done.Err != nil {
+		t.Errorf("Completed = %+v, want the result and no error", done)
+	}
+}
+
+func TestBudgetWarningComesOnceBeforeTheLimit(t *testing.T) {
+	rec := &recorder{}
+	orch := New(Config{
+		AgentBin: agentBin("polite"), // tokens_in goes up 100 a heartbeat
+		HeartbeatTimeout: 5 * time.Second,
+		MaxTokensIn: 1000,
+		Observer: rec,
+	})
+
+	_, err := orch.Run(context.Background(), Task{ID: "warned", Repo: t.TempDir()})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("err = %v, want ErrBudgetExceeded", err)
+	}
+
+	var warnings []BudgetWarning
+	for _, ev := range rec.events {
+		switch ev := ev.(type) {
+		case BudgetWarning:
+			warnings = append(warnings, ev)
+		case Killed:
+			t.Errorf("agent killed (%v), but it wraps up when asked", ev.Reason)
+		}
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %+v, want one", warnings)
+	}
+	if w := warnings[0]; w.Budget != BudgetTokensIn || w.Used != 800 || w.Limit != 1000 {
+		t.Errorf("warning = %+v, want tokens_in at 800 of 1000", w)
+	}
+}
+
+func TestKilledSaysWhy(t *testing.T) {
+	rec := &recorder{}
+	orch := New(Config{
+		AgentBin: agentBin("hang"),
+		HeartbeatTimeout: 500 * time.Millisecond,
+		Observer: rec,
+	})
+
+	_, err := orch.Run(context.Background(), Task{ID: "killed", Repo: t.TempDir()})
+	if !errors.Is(err, ErrHeartbeatTimeout) {
+		t.Fatalf("err = %v, want ErrHeartbeatTimeout", err)
+	}
+
+	kinds := rec.kinds()
+	i := slices.Index(kinds, "orchestrator.Killed")
+	if i < 0 {
+		t.Fatalf("no Killed event in %v", kinds)
+	}
+	if reason := rec.events[i].(Killed).Reason; !errors.Is(reason, ErrHeartbeatTimeout) {
+		t.Errorf("Killed.Reason = %v, want ErrHeartbeatTimeout", reason)
+	}
+	if exited := rec.events[i+1].(Exited); exited.Signal == nil {
+		t.Errorf("Exited = %+v, want a signal", exited)
+	}
+}
@@ -0,0 +1,153 @@
+package orchestrator
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tparlmer/leopold/protocol"
+	"github.com/tparlmer/leopold/workspace"
+)
+
+// FileReport is what an agent changed in its repo, as seen by the
+// orchestrator rather than reported by the agent, and where the two
+// disagree. Paths are slash-separated and relative to the repo.
+type FileReport struct {
+	workspace.Changes
+
+	Unreported []string // changed, but missing from the agent's FilesChanged
+	Unchanged  []string // in FilesChanged, but not actually changed
+	Disallowed []string // changed, and outside Config.AllowedPaths or Task.Files
+	Reverted   []string // disallowed changes undone (only with PathRevert)
+}
+
+// PathPolicy is what happens when an agent changes files it wasn't
+// allowed to.
+type PathPolicy int
+
+const (
+	// PathFail fails the task with ErrDisallowedPath, leaving the
+	// changes in place for inspection.
+	PathFail PathPolicy = iota
+	// PathFlag lists the changes in FileReport.Disallowed and
+	// otherwise lets the task be.
+	PathFlag
+	// PathRevert undoes the disallowed changes in the working tree,
+	// keeping the rest, and lists them in FileReport.Reverted. The
+	// task's outcome stands.
+	PathRevert
+)
+
+func (p PathPolicy) String() string {
+	switch p {
+	case PathFail:
+		return "fail"
+	case PathFlag:
+		return "flag"
+	case PathRevert:
+		return "revert"
+	default:
+		return fmt.Sprintf("PathPolicy(%d)", int(p))
+	}
+}
+
+// restricted reports whether task may only change some paths.
+func (o *Orchestrator) restricted(task Task) bool {
+	return o.config.AllowedPaths != nil || task.Files != nil
+}
+
+// allowed reports whether task may change p: it has to be covered by
+// both Config.AllowedPaths and Task.Files, where set.
+func (o *Orchestrator) allowed(task Task, p string) bool {
+	return (o.config.AllowedPaths == nil || pathAllowed(o.config.AllowedPaths, p)) &&
+		(task.Files == nil || pathAllowed(task.Files, p))
+}
+
+// fileReport checks changes against the agent's claims and the allowed
+// paths.
+func (o *Orchestrator) fileReport(task Task, repo string, changes *workspace.Changes, complete *protocol.CompleteMessage) *FileReport {
+	r := &FileReport{Changes: *changes}
+
+	var reported []string
+	if complete != nil {
+		for _, f := range complete.FilesChanged {
+			reported = append(reported, repoPath(repo, f))
+		}
+	}
+	changed := changes.All()
+	for _, f := range changed {
+		if !slices.Contains(reported, f) {
+			r.Unreported = append(r.Unreported, f)
+		}
+		if !o.allowed(task, f) {
+			r.Disallowed = append(r.Disallowed, f)
+		}
+	}
+	for _, f := range reported {
+		if !slices.Contains(changed, f) && !slices.Contains(r.Unchanged, f) {
+			r.Unchanged = append(r.Unchanged, f)
+		}
+	}
+	return r
+}
+
+// repoPath normalizes a path the agent reported to match Changes:
+// relative to the repo, slash-separated, clean.
+func repoPath(repo, p string) string {
+	if filepath.IsAbs(p) {
+		if abs, err := filepath.Abs(repo); err == nil {
+			if rel, err := filepath.Rel(abs, p); err == nil {
+				p = rel
+			}
+		}
+	}
+	return path.Clean(filepath.ToSlash(p))
+}
+
+// pathAllowed reports whether p is covered by one of patterns. A
+// pattern covers the file it names, everything under it if it names a
+// directory, and likewise for what it matches as a glob; see
+// Task.Files.
+func pathAllowed(patterns []string, p string) bool {
+	for _, pat := range patterns {
+		pat = path.Clean(filepath.ToSlash(pat))
+		if pat == "." || p == pat || strings.HasPrefix(p, pat+"/") {
+			return true
+		}
+		// Matching any leading part of p will do: that's a directory
+		// p is under
+		patElems, elems := strings.Split(pat, "/"), strings.Split(p, "/")
+		for i := range elems {
+			if globMatch(patElems, elems[:i+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globMatch matches a path against a glob one element at a time: "**"
+// stands for any number of elements, anything else is a path.Match
+// pattern for exactly one.
+func globMatch(pat, elems []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := range len(elems) + 1 {
+				if globMatch(pat[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], elems[0]); !ok {
+			return false
+		}
+		pat, elems = pat[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
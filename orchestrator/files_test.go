@@ -0,0 +1,195 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPathAllowed(t *testing.T) {
+	patterns := []string{"docs", "internal/auth/", "*.md", "cmd/*/main.go", "web/**/*.css", "**/testdata"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"docs", true},
+		{"docs/guide/intro.txt", true},
+		{"docsearch.go", false},
+		{"internal/auth/login.go", true},
+		{"internal/authz/check.go", false},
+		{"README.md", true},
+		{"sub/README.md", false},
+		{"cmd/leopold/main.go", true},
+		{"cmd/leopold/flags.go", false},
+		{"web/site.css", true},
+		{"web/themes/dark/site.css", true},
+		{"web/themes/site.js", false},
+		{"site.css", false},
+		{"testdata/x.json", true},
+		{"orchestrator/testdata/bin/echo", true},
+		{"orchestrator/testdatafile", false},
+	}
+	for _, tt := range tests {
+		if got := pathAllowed(patterns, tt.path); got != tt.want {
+			t.Errorf("pathAllowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFilesComparesClaimsWithReality(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("happy"), // claims main.go, changes nothing
+		HeartbeatTimeout: 5 * time.Second,
+		CheckFiles: true,
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "files-1", Repo: t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Files == nil {
+		t.Fatal("no file report")
+	}
+	if len(res.Files.All()) != 0 {
+		t.Errorf("changes = %v, want none", res.Files.All())
+	}
+	if !slices.Equal(res.Files.Unchanged, []string{"main.go"}) {
+		t.Errorf("Unchanged = %v, want [main.go]", res.Files.Unchanged)
+	}
+}
+
+func TestAllowedPathsFailsStrayChanges(t *testing.T) {
+	for _, tt := range []struct {
+		allowed []string
+		wantErr bool
+	}{
+		{[]string{"src"}, true},
+		{[]string{"*.txt"}, false},
+	} {
+		orch := New(Config{
+			AgentBin: agentBin("echo"), // writes notes.txt, reports nothing
+			HeartbeatTimeout: 5 * time.Second,
+			AllowedPaths: tt.allowed,
+		})
+
+		res, err := orch.Run(context.Background(), Task{ID: "files-2", Prompt: "write notes.txt", Repo: t.TempDir()})
+		if errors.Is(err, ErrDisallowedPath) != tt.wantErr {
+			t.Errorf("allowed %v: err = %v, want disallowed: %v", tt.allowed, err, tt.wantErr)
+		}
+		if !slices.Equal(res.Files.Created, []string{"notes.txt"}) {
+			t.Errorf("allowed %v: Created = %v, want [notes.txt]", tt.allowed, res.Files.Created)
+		}
+		if !slices.Equal(res.Files.Unreported, []string{"notes.txt"}) {
+			t.Errorf("allowed %v: Unreported = %v, want [notes.txt]", tt.allowed, res.Files.Unreported)
+		}
+		if tt.wantErr && !slices.Equal(res.Files.Disallowed, []string{"notes.txt"}) {
+			t.Errorf("allowed %v: Disallowed = %v, want [notes.txt]", tt.allowed, res.Files.Disallowed)
+		}
+	}
+}
+
+func TestRollbackUndoesFailedTask(t *testing.T) {
+	for _, tt := range []struct {
+		allowed []string
+		wantErr bool
+	}{
+		{[]string{"src"}, true},
+		{[]string{"*.txt"}, false},
+	} {
+		repo := t.TempDir()
+		orch := New(Config{
+			AgentBin: agentBin("echo"),
+			HeartbeatTimeout: 5 * time.Second,
+			AllowedPaths: tt.allowed,
+			Rollback: true,
+		})
+
+		res, err := orch.Run(context.Background(), Task{ID: "rollback-1", Prompt: "write notes.txt", Repo: repo})
+		if errors.Is(err, ErrDisallowedPath) != tt.wantErr {
+			t.Fatalf("allowed %v: err = %v, want disallowed: %v", tt.allowed, err, tt.wantErr)
+		}
+		_, statErr := os.Stat(filepath.Join(repo, "notes.txt"))
+		if tt.wantErr {
+			if res.RolledBack == nil || !slices.Equal(res.RolledBack.Created, []string{"notes.txt"}) {
+				t.Errorf("allowed %v: RolledBack = %+v, want notes.txt created", tt.allowed, res.RolledBack)
+			}
+			if !errors.Is(statErr, fs.ErrNotExist) {
+				t.Errorf("allowed %v: notes.txt survived the rollback: %v", tt.allowed, statErr)
+			}
+		} else {
+			if res.RolledBack != nil {
+				t.Errorf("allowed %v: RolledBack = %+v, want nil", tt.allowed, res.RolledBack)
+			}
+			if statErr != nil {
+				t.Errorf("allowed %v: notes.txt: %v", tt.allowed, statErr)
+			}
+		}
+	}
+}
+
+func TestTaskFilesNarrowAllowedPaths(t *testing.T) {
+	for _, tt := range []struct {
+		policy   PathPolicy
+		wantErr  bool
+		wantFile bool
+		reverted []string
+	}{
+		{PathFail, true, true, nil},
+		{PathFlag, false, true, nil},
+		{PathRevert, false, false, []string{"notes.txt"}},
+	} {
+		repo := t.TempDir()
+		orch := New(Config{
+			AgentBin: agentBin("echo"),
+			HeartbeatTimeout: 5 * time.Second,
+			AllowedPaths: []string{"*.txt"}, // allows notes.txt, but the task doesn't
+			PathPolicy: tt.policy,
+		})
+
+		res, err := orch.Run(context.Background(), Task{ID: "files-3", Prompt: "write notes.txt", Repo: repo, Files: []string{"src"}})
+		if errors.Is(err, ErrDisallowedPath) != tt.wantErr {
+			t.Errorf("%v: err = %v, want disallowed: %v", tt.policy, err, tt.wantErr)
+		}
+		if !slices.Equal(res.Files.Disallowed, []string{"notes.txt"}) {
+			t.Errorf("%v: Disallowed = %v, want [notes.txt]", tt.policy, res.Files.Disallowed)
+		}
+		if !slices.Equal(res.Files.Reverted, tt.reverted) {
+			t.Errorf("%v: Reverted = %v, want %v", tt.policy, res.Files.Reverted, tt.reverted)
+		}
+		if _, err := os.Stat(filepath.Join(repo, "notes.txt")); (err == nil) != tt.wantFile {
+			t.Errorf("%v: notes.txt there: %v, want %v", tt.policy, err == nil, tt.wantFile)
+		}
+	}
+}
+
+func TestWatchPathsKillsOnStrayWrite(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("live watching needs inotify")
+	}
+	orch := New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+		AllowedPaths: []string{"src"},
+		WatchPaths: true,
+	})
+
+	start := time.Now()
+	res, err := orch.Run(context.Background(), Task{ID: "files-4", Prompt: "write notes.txt, then hang", Repo: t.TempDir()})
+	if !errors.Is(err, ErrDisallowedPath) || !strings.Contains(err.Error(), "while running") {
+		t.Fatalf("err = %v, want a live ErrDisallowedPath", err)
+	}
+	// Killed on the write, not at the heartbeat timeout
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("took %s to kill the agent", elapsed)
+	}
+	if res.Signal == nil {
+		t.Error("agent wasn't killed")
+	}
+}
@@ -0,0 +1,212 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// GraphOptions controls how RunGraph runs a task graph.
+type GraphOptions struct {
+	// UpstreamContext appends the summaries of a task's dependencies to
+	// its Context, so each agent knows what the ones before it did.
+	UpstreamContext bool
+}
+
+// GraphResult is what happened to one task in a graph.
+type GraphResult struct {
+	Result *TaskResult // never nil; empty if the task never ran
+	Err    error       // what Run returned, or ErrUpstreamFailed if skipped
+}
+
+// Skipped reports whether the task never ran because something it
+// depends on failed.
+func (r *GraphResult) Skipped() bool {
+	return errors.Is(r.Err, ErrUpstreamFailed)
+}
+
+// succeeded is what a downstream task needs from its dependencies: no
+// error, and an agent that says it's done. An agent that completes with
+// state "failed" is no foundation to build on.
+func (r *GraphResult) succeeded() bool {
+	return r.Err == nil && r.Result.Complete != nil && r.Result.Complete.State == "done"
+}
+
+// RunGraph runs tasks on the pool in dependency order: each task starts
+// once everything in its DependsOn has succeeded, and tasks that don't
+// depend on each other run in parallel, up to the pool's size. When a
+// task fails, everything downstream of it is skipped.
+//
+// The graph is checked before anything runs. Duplicate or empty IDs,
+// dependencies on tasks that aren't in the graph, and cycles (wrapping
+// ErrCycle) are returned as an error, with nil results. Otherwise the
+// error is nil and every task has a GraphResult, keyed by ID.
+func (p *Pool) RunGraph(ctx context.Context, tasks []Task, opts GraphOptions) (map[string]*GraphResult, error) {
+	byID, err := checkGraph(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	// Who's waiting on whom
+	waiting := make(map[string]int, len(tasks))
+	dependents := make(map[string][]string)
+	for _, t := range tasks {
+		waiting[t.ID] = len(t.DependsOn)
+		for _, dep := range t.DependsOn {
+			dependents[dep] = append(dependents[dep], t.ID)
+		}
+	}
+
+	results := make(map[string]*GraphResult, len(tasks))
+	type finished struct {
+		id  string
+		res *TaskResult
+		err error
+	}
+	done := make(chan finished)
+	inFlight := 0
+
+	start := func(t Task) {
+		if opts.UpstreamContext {
+			t.Context = upstreamContext(t, results)
+		}
+		fut, err := p.Submit(ctx, t)
+		if err != nil {
+			results[t.ID] = &GraphResult{Result: &TaskResult{}, Err: err}
+			return
+		}
+		inFlight++
+		go func() {
+			res, err := fut.Wait()
+			done <- finished{t.ID, res, err}
+		}()
+	}
+
+	// skip marks everything downstream of a failed task, depth first.
+	var skip func(id, cause string)
+	skip = func(id, cause string) {
+		for _, d := range dependents[id] {
+			if _, ok := results[d]; ok {
+				continue
+			}
+			results[d] = &GraphResult{
+				Result: &TaskResult{},
+				Err:    fmt.Errorf("%w: %s", ErrUpstreamFailed, cause),
+			}
+			skip(d, cause)
+		}
+	}
+
+	// settle handles a task that has its result: release or skip what
+	// depends on it.
+	var settle func(id string)
+	settle = func(id string) {
+		if !results[id].succeeded() {
+			skip(id, id)
+			return
+		}
+		for _, d := range dependents[id] {
+			waiting[d]--
+			if waiting[d] == 0 {
+				start(byID[d])
+				if _, failed := results[d]; failed {
+					// Couldn't even be submitted
+					settle(d)
+				}
+			}
+		}
+	}
+
+	for _, t := range tasks {
+		if len(t.DependsOn) == 0 {
+			start(t)
+			if _, failed := results[t.ID]; failed {
+				settle(t.ID)
+			}
+		}
+	}
+	for inFlight > 0 {
+		f := <-done
+		inFlight--
+		results[f.id] = &GraphResult{Result: f.res, Err: f.err}
+		settle(f.id)
+	}
+	return results, nil
+}
+
+// upstreamContext is t.Context followed by what each of its
+// dependencies reported.
+func upstreamContext(t Task, results map[string]*GraphResult) string {
+	var b strings.Builder
+	b.WriteString(t.Context)
+	for _, dep := range t.DependsOn {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "Task %q, which this task depends on, is done:\n%s",
+			dep, results[dep].Result.Complete.Summary)
+	}
+	return b.String()
+}
+
+// checkGraph validates a task graph and indexes it by ID.
+func checkGraph(tasks []Task) (map[string]Task, error) {
+	byID := make(map[string]Task, len(tasks))
+	for _, t := range tasks {
+		if t.ID == "" {
+			return nil, fmt.Errorf("task graph: task with empty ID")
+		}
+		if _, dup := byID[t.ID]; dup {
+			return nil, fmt.Errorf("task graph: duplicate task ID %q", t.ID)
+		}
+		byID[t.ID] = t
+	}
+	for _, t := range tasks {
+		for _, dep := range t.DependsOn {
+			if _, ok := byID[dep]; !ok {
+				return nil, fmt.Errorf("task graph: %q depends on unknown task %q", t.ID, dep)
+			}
+		}
+	}
+
+	// Depth-first search; reaching a task that's still on the stack
+	// means we've gone round in a circle.
+	const (
+		unvisited = iota
+		onStack
+		visited
+	)
+	state := make(map[string]int, len(tasks))
+	var stack []string
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case onStack:
+			i := len(stack) - 1
+			for stack[i] != id {
+				i--
+			}
+			cycle := append(stack[i:], id)
+			return fmt.Errorf("%w: %s", ErrCycle, strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		state[id] = onStack
+		stack = append(stack, id)
+		for _, dep := range byID[id].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+		return nil
+	}
+	for _, t := range tasks {
+		if err := visit(t.ID); err != nil {
+			return nil, fmt.Errorf("task graph: %w", err)
+		}
+	}
+	return byID, nil
+}
@@ -0,0 +1,145 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunGraphRejectsInvalidGraphs(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []Task
+		want  string
+	}{
+		{
+			"cycle",
+			[]Task{
+				{ID: "a", DependsOn: []string{"c"}},
+				{ID: "b", DependsOn: []string{"a"}},
+				{ID: "c", DependsOn: []string{"b"}},
+			},
+			"a -> c -> b -> a",
+		},
+		{
+			"self dependency",
+			[]Task{{ID: "a", DependsOn: []string{"a"}}},
+			"a -> a",
+		},
+		{
+			"unknown dependency",
+			[]Task{{ID: "a", DependsOn: []string{"nope"}}},
+			`unknown task "nope"`,
+		},
+		{
+			"duplicate",
+			[]Task{{ID: "a"}, {ID: "a"}},
+			`duplicate task ID "a"`,
+		},
+	}
+
+	pool := NewPool(New(Config{AgentBin: agentBin("echo")}), 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := pool.RunGraph(context.Background(), tt.tasks, GraphOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want it to mention %q", err, tt.want)
+			}
+			if results != nil {
+				t.Errorf("got results for an invalid graph")
+			}
+			if r, q := pool.Stats(); r+q != 0 {
+				t.Errorf("invalid graph started tasks")
+			}
+		})
+	}
+
+	_, err := pool.RunGraph(context.Background(), tests[0].tasks, GraphOptions{})
+	if !errors.Is(err, ErrCycle) {
+		t.Errorf("err = %v, want ErrCycle", err)
+	}
+}
+
+func TestRunGraphRunsInDependencyOrder(t *testing.T) {
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+	}), 4)
+	dir := t.TempDir()
+
+	// Three independent tasks feeding one that depends on them all
+	tasks := []Task{
+		{ID: "docs", Prompt: "docs", Repo: dir, DependsOn: []string{"refactor", "lint", "deps"}},
+		{ID: "refactor", Prompt: "refactor", Repo: dir},
+		{ID: "lint", Prompt: "lint", Repo: dir},
+		{ID: "deps", Prompt: "deps", Repo: dir},
+	}
+
+	start := time.Now()
+	results, err := pool.RunGraph(context.Background(), tasks, GraphOptions{UpstreamContext: true})
+	if err != nil {
+		t.Fatalf("RunGraph: %v", err)
+	}
+	for id, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", id, r.Err)
+		}
+	}
+
+	// Each task takes 300ms: run one after another, that's 1.2s
+	if elapsed := time.Since(start); elapsed > 1100*time.Millisecond {
+		t.Errorf("took %s, want the independent tasks run in parallel", elapsed)
+	}
+
+	summary := results["docs"].Result.Complete.Summary
+	for _, want := range []string{`"refactor"`, "did refactor", `"lint"`, "did lint", `"deps"`, "did deps"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("docs wasn't told %q by its upstream tasks: %q", want, summary)
+		}
+	}
+}
+
+func TestRunGraphSkipsDownstreamOfFailure(t *testing.T) {
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+	}), 2)
+	dir := t.TempDir()
+
+	tasks := []Task{
+		{ID: "refactor", Prompt: "crash", Repo: dir},
+		{ID: "tests", Prompt: "tests", Repo: dir, DependsOn: []string{"refactor"}},
+		{ID: "docs", Prompt: "docs", Repo: dir, DependsOn: []string{"tests"}},
+		{ID: "audit", Prompt: "fail", Repo: dir},
+		{ID: "report", Prompt: "report", Repo: dir, DependsOn: []string{"audit"}},
+		{ID: "lint", Prompt: "lint", Repo: dir},
+	}
+
+	results, err := pool.RunGraph(context.Background(), tasks, GraphOptions{})
+	if err != nil {
+		t.Fatalf("RunGraph: %v", err)
+	}
+	if len(results) != len(tasks) {
+		t.Fatalf("got %d results, want %d", len(results), len(tasks))
+	}
+
+	if !errors.Is(results["refactor"].Err, ErrCrashed) {
+		t.Errorf("refactor: err = %v, want ErrCrashed", results["refactor"].Err)
+	}
+	if results["audit"].Err != nil || results["audit"].Result.Complete.State != "failed" {
+		t.Errorf("audit: want a clean run that reported failure, got %+v", results["audit"])
+	}
+	if results["lint"].Err != nil {
+		t.Errorf("lint: %v", results["lint"].Err)
+	}
+	for _, id := range []string{"tests", "docs", "report"} {
+		if !results[id].Skipped() {
+			t.Errorf("%s: err = %v, want it skipped", id, results[id].Err)
+		}
+	}
+	if err := results["docs"].Err; !strings.Contains(err.Error(), "refactor") {
+		t.Errorf("docs: err = %v, want it to name the task that failed", err)
+	}
+}
@@ -0,0 +1,89 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logRecords decodes the JSON log lines in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLoggerRecordsKillsAndProtocolErrors(t *testing.T) {
+	tests := []struct {
+		agent  string
+		msg    string // a record that must be logged
+		reason string // and part of its reason
+	}{
+		{"garbage", "protocol error", "invalid character"},
+		{"garbage", "killing agent", "invalid character"},
+		{"hang", "killing agent", "heartbeat timeout"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		orch := New(Config{
+			AgentBin: agentBin(tt.agent),
+			HeartbeatTimeout: 500 * time.Millisecond,
+			Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		})
+		orch.Run(context.Background(), Task{ID: "logged", Repo: t.TempDir()})
+
+		var found map[string]any
+		for _, r := range logRecords(t, &buf) {
+			if r["task_id"] != "logged" {
+				t.Errorf("%s: record without the task ID: %v", tt.agent, r)
+			}
+			if r["msg"] == tt.msg && found == nil {
+				found = r
+			}
+		}
+		if found == nil {
+			t.Errorf("%s: no %q record in:\n%s", tt.agent, tt.msg, buf.String())
+			continue
+		}
+		if reason, _ := found["reason"].(string); !strings.Contains(reason, tt.reason) {
+			t.Errorf("%s: %s reason = %q, want it to mention %q", tt.agent, tt.msg, reason, tt.reason)
+		}
+		if found["pid"] == nil || found["version"] != float64(1) {
+			t.Errorf("%s: %s without pid and version: %v", tt.agent, tt.msg, found)
+		}
+	}
+}
+
+func TestLoggerWarnsWhenCgroupUnavailable(t *testing.T) {
+	var buf bytes.Buffer
+	orch := New(Config{
+		AgentBin: agentBin("happy"),
+		HeartbeatTimeout: 5 * time.Second,
+		Cgroup: &CgroupConfig{Parent: t.TempDir(), MemoryMaxMB: 64}, // not a cgroup
+		Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+	})
+	if _, err := orch.Run(context.Background(), Task{ID: "logged", Repo: t.TempDir()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, r := range logRecords(t, &buf) {
+		if r["msg"] == "cgroup limits not applied, running unconfined" {
+			if r["level"] != "WARN" || r["task_id"] != "logged" {
+				t.Errorf("record = %v, want a warning with the task ID", r)
+			}
+			return
+		}
+	}
+	t.Errorf("no warning about the missing cgroup in:\n%s", buf.String())
+}
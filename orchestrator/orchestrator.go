@@ -1,14 +1,22 @@
 package orchestrator
 
 import (
-	"encoding/json"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
-	"bufio"
 
 	"github.com/tparlmer/leopold/protocol"
+	"github.com/tparlmer/leopold/workspace"
 )
 
 // Config holds the runtime parameters for the orchestrator.
@@ -17,9 +25,53 @@ import (
 type Config struct {
 	AgentBin string // path to the agent binary
 	HeartbeatTimeout time.Duration // kill agent if silent this long
-	MaxRSSMB int // RSS budget (0 = unlimited)
+	MaxRSSMB int // RSS budget, on measured or self-reported RSS (0 = unlimited)
+	RSSSampleInterval time.Duration // how often to measure the agent's RSS (0 = DefaultRSSSampleInterval)
+	CancelGrace time.Duration // how long a cancelled agent gets to wrap up (0 = DefaultCancelGrace)
+	TermGrace time.Duration // how long after SIGTERM before SIGKILL (0 = DefaultTermGrace)
+	Blocked BlockedHandler // policy for BlockedMessage (nil = FailOnBlocked)
+	Cgroup *CgroupConfig // kernel-enforced limits via cgroup v2, Linux only (nil = none)
+	Handshake bool // require a HelloMessage and negotiate the protocol version before init
+	MaxMessageBytes int // longest protocol line accepted from the agent (0 = protocol.DefaultMaxMessageBytes)
+	MaxChunkedBytes int // largest message reassembled from chunks (0 = protocol.DefaultMaxChunkedBytes)
+	StderrTailBytes int // how much of the agent's stderr to attach to crash and timeout errors (0 = DefaultStderrTailBytes)
+	LogDir string // if set, timestamped stdin/stdout/stderr transcripts go in LogDir/<task ID>/
+	MaxDuration time.Duration // wall-clock budget per task (0 = unlimited)
+	MaxTokensIn int // input token budget, per heartbeat counters (0 = unlimited)
+	MaxTokensOut int // output token budget (0 = unlimited)
+	MaxTokens int // combined input+output token budget (0 = unlimited)
+	Pricing map[string]Price // per-token prices by model, for agents that don't report cost ("" = unnamed model)
+	MaxCostUSD float64 // cost budget, reported or computed from Pricing (0 = unlimited)
+	Verify *VerifyConfig // check completed tasks with a command and retry on failure (nil = trust the agent)
+	Workspace *workspace.Manager // run each task in its own git worktree (nil = directly in Task.Repo)
+	CheckFiles bool // snapshot Task.Repo and report what the agent really changed
+	AllowedPaths []string // paths and globs any task may change, with the syntax of Task.Files; implies CheckFiles (nil = anything goes)
+	PathPolicy PathPolicy // what to do about changes outside AllowedPaths or Task.Files (zero = PathFail)
+	WatchPaths bool // also kill the agent the moment it writes outside them, on Linux
+	WatchFiles bool // watch Task.Repo and report the agent's edits as they happen, on Linux
+	Observer Observer // told about task events as they happen (nil = none)
+	Events chan<- Event // receives task events as they happen; never blocked on, so buffer it (nil = none)
+	BudgetWarning float64 // fraction of a budget used before a BudgetWarning event (0 = DefaultBudgetWarning)
+	Logger *slog.Logger // where transitions, kills and protocol trouble get logged (nil = nowhere)
+	Rollback bool // put Task.Repo back as it was when a task fails (not needed with Workspace)
 }
 
+// Defaults for the cancellation escalation ladder:
+// CancelMessage -> CancelGrace -> SIGTERM -> TermGrace -> SIGKILL.
+const (
+	DefaultCancelGrace = 5 * time.Second
+	DefaultTermGrace = 2 * time.Second
+)
+
+// DefaultRSSSampleInterval is how often the orchestrator measures the
+// agent's process tree when Config.RSSSampleInterval is zero.
+const DefaultRSSSampleInterval = 250 * time.Millisecond
+
+// stdoutDrainTimeout bounds how long the control loop keeps reading
+// stdout once the agent has exited. Anything the agent started can
+// inherit the pipe and hold it open indefinitely.
+const stdoutDrainTimeout = 200 * time.Millisecond
+
 // Orchestrator supervises a single agent process per RunTask call.
 // Stateless between tasks - all per-task state lives inside RunTask
 type Orchestrator struct {
@@ -29,189 +81,878 @@ type Orchestrator struct {
 // msgResult carries a parsed message (or error) from the reader goroutine
 // to the control loop.
 type msgResult struct {
-	msg interface{}
+	msg protocol.Message
 	err error
 }
 
 // New creates an orchestrator with the given configuration
 func New(cfg Config) *Orchestrator {
-	return &Orchestrator{config: cfg}
-}
-
-// sendMessage marshals a protocol message and writes it as a JSON line.
-// used to send InitMessage and TaskMessage to the agent's stdin.
-func sendMessage(w io.Writer, msg interface{}) error {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("marshal message: %w", err)
+	if cfg.CancelGrace == 0 {
+		cfg.CancelGrace = DefaultCancelGrace
+	}
+	if cfg.TermGrace == 0 {
+		cfg.TermGrace = DefaultTermGrace
+	}
+	if cfg.Blocked == nil {
+		cfg.Blocked = FailOnBlocked
+	}
+	if cfg.RSSSampleInterval <= 0 {
+		cfg.RSSSampleInterval = DefaultRSSSampleInterval
+	}
+	if cfg.StderrTailBytes <= 0 {
+		cfg.StderrTailBytes = DefaultStderrTailBytes
+	}
+	if cfg.BudgetWarning == 0 {
+		cfg.BudgetWarning = DefaultBudgetWarning
 	}
-	// need to append newline because JSON protocol requires one JSON object per line
-	data = append(data, '\n')
-	_, err = w.Write(data)
-	return err
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
+	}
+	return &Orchestrator{config: cfg}
 }
 
-// startREader launches a goroutine that reads JSON lines from the agent's
-// stdout, parses each line via ParseMessage, and sends results to the
-// returned channel. The channel is closed when the pipe closes or errors.
-func startReader(stdout io.Reader) <-chan msgResult {
+// startREader launches a goroutine that decodes messages from the agent's
+// stdout and sends results to the returned channel. The channel is
+// closed when the pipe closes or errors.
+// Closing stop releases the goroutine if nobody is receiving anymore.
+func startReader(dec *protocol.Decoder, stop <-chan struct{}) <-chan msgResult {
 	ch := make(chan msgResult)
+	send := func(r msgResult) bool {
+		select {
+		case ch <- r:
+			return true
+		case <-stop:
+			return false
+		}
+	}
 	go func() {
 		defer close(ch)
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			msg, err := protocol.ParseMessage(scanner.Bytes())
-			if err != nil {
-				ch <- msgResult{err: fmt.Errorf("parse: %w", err)}
-				continue
+		for {
+			msg, err := dec.Decode()
+			if err == io.EOF {
+				// Clean EOF - pipe closed, we're done
+				return
+			}
+			var perr *protocol.ParseError
+			if err != nil && !errors.As(err, &perr) {
+				// I/O error - the stream is unusable
+				send(msgResult{err: fmt.Errorf("read stdout: %w", err)})
+				return
+			}
+			if !send(msgResult{msg: msg, err: err}) {
+				return
 			}
-			ch <- msgResult{msg: msg}
-		}
-		// scanner.Err() is nil on celan EOF (pipe closed).
-		// Non-nil means an I/O error - but either way, we're done
-		if err := scanner.Err(); err != nil {
-			ch <- msgResult{err: fmt.Errorf("read stdout: %w", err)}
 		}
 	}()
 	return ch
 }
 
+// outboxSize is how many messages may wait for the agent to read its
+// stdin before the orchestrator gives up on telling it anything more.
+const outboxSize = 8
+
+// startWriter launches a goroutine that encodes the messages sent on
+// the returned channel to the agent's stdin, in order. A write blocks
+// until the agent reads, and the control loop can't afford to wait on
+// it. The first write error is sent on the error channel, and the
+// goroutine exits. Closing stop releases it between writes; a write in
+// progress ends when the agent is killed.
+func startWriter(enc *protocol.Encoder, stop <-chan struct{}) (chan<- protocol.Message, <-chan error) {
+	msgs := make(chan protocol.Message, outboxSize)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case msg := <-msgs:
+				if err := enc.Encode(msg); err != nil {
+					errs <- fmt.Errorf("send %s: %w", msg.MessageType(), err)
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return msgs, errs
+}
+
 // RunTask spawns an agent, sends it a task, and supervises it to completion.
 // Returns the agent's CompleteMessage on success, or an error fi the agent
 // misbehaved (timeout, crash, RSS exceeded, etc.).
 func (o *Orchestrator) RunTask(taskID, prompt, repo string) (*protocol.CompleteMessage, error) {
+	return o.RunTaskContext(context.Background(), taskID, prompt, repo)
+}
+
+// RunTaskContext is RunTask with cancellation. When ctx is done (or the
+// BlockedHandler decides to cancel) the agent is sent a CancelMessage
+// and given CancelGrace to reply with a CompleteMessage in state
+// "cancelled". If it doesn't, it gets SIGTERM, then SIGKILL after
+// TermGrace.
+//
+// A cancelled task always returns an error wrapping ErrCancelled. The
+// agent's CompleteMessage is returned with it when the agent wrapped up
+// within the grace period, whatever state it reported, and nil when it
+// had to be killed.
+func (o *Orchestrator) RunTaskContext(ctx context.Context, taskID, prompt, repo string) (*protocol.CompleteMessage, error) {
+	result, err := o.Run(ctx, Task{ID: taskID, Prompt: prompt, Repo: repo})
+	return result.Complete, err
+}
+
+// Run is RunTaskContext with the full picture: the returned TaskResult
+// is never nil and describes what the orchestrator observed, whether or
+// not the task succeeded.
+//
+// With Config.Verify set, a completed task isn't done until its
+// verification command passes; see VerifyConfig. With Config.Workspace
+// set, the agent works in a worktree of Task.Repo instead of the repo
+// itself; see the workspace package. Otherwise, with Config.Rollback
+// set, a task that fails has its changes to Task.Repo undone.
+//
+// Config.Observer and Config.Events hear about the task as it goes; see
+// Event.
+func (o *Orchestrator) Run(ctx context.Context, task Task) (*TaskResult, error) {
+	res, err := o.runTask(ctx, task)
+	o.emit(Completed{EventHeader: eventHeader(task), Result: res, Err: err})
+	if err != nil {
+		o.config.Logger.Warn("task failed", "task_id", task.ID, "reason", err)
+	} else if res.Complete != nil {
+		o.config.Logger.Info("task finished", "task_id", task.ID, "state", res.Complete.State)
+	}
+	return res, err
+}
+
+// runTask runs task however it's configured to be run.
+func (o *Orchestrator) runTask(ctx context.Context, task Task) (*TaskResult, error) {
+	switch {
+	case o.config.Workspace != nil:
+		return o.runInWorkspace(ctx, task)
+	case o.config.Rollback:
+		return o.runWithRollback(ctx, task)
+	}
+	return o.run(ctx, task)
+}
+
+// run runs task where it stands, verified if so configured.
+func (o *Orchestrator) run(ctx context.Context, task Task) (*TaskResult, error) {
+	if o.config.Verify != nil {
+		return o.runVerified(ctx, task)
+	}
+	return o.runOnce(ctx, task)
+}
+
+// runInWorkspace runs task in a fresh worktree and finishes the
+// worktree however the task went.
+func (o *Orchestrator) runInWorkspace(ctx context.Context, task Task) (*TaskResult, error) {
+	ws, err := o.config.Workspace.Create(ctx, task.Repo, task.ID)
+	if err != nil {
+		return &TaskResult{}, fmt.Errorf("create workspace: %w", err)
+	}
+	// Task.Repo may be a subdirectory, and so is everything relative to it
+	task.Repo = filepath.Join(ws.Dir, ws.Prefix)
+	o.config.Logger.Debug("workspace created", "task_id", task.ID, "dir", ws.Dir, "branch", ws.Branch)
+
+	res, err := o.run(ctx, task)
+
+	// Clean up even if the caller has given up on the task
+	succeeded := err == nil && res.Complete != nil && res.Complete.State == "done"
+	out, finishErr := o.config.Workspace.Finish(context.WithoutCancel(ctx), ws, succeeded)
+	res.Workspace = out
+	if finishErr != nil {
+		o.config.Logger.Error("finishing workspace failed", "task_id", task.ID, "dir", ws.Dir, "reason", finishErr)
+		err = errors.Join(err, fmt.Errorf("finish workspace: %w", finishErr))
+	} else {
+		o.config.Logger.Debug("workspace finished", "task_id", task.ID, "branch", out.Branch, "merged", out.Merged, "kept", out.Kept)
+	}
+	return res, err
+}
+
+// runWithRollback backs up Task.Repo, runs task, and restores the
+// backup if the task failed.
+func (o *Orchestrator) runWithRollback(ctx context.Context, task Task) (*TaskResult, error) {
+	dir := cmp.Or(task.Repo, ".")
+	backup, err := workspace.Save(ctx, dir)
+	if err != nil {
+		return &TaskResult{}, fmt.Errorf("back up %s: %w", dir, err)
+	}
+	defer backup.Discard()
+
+	res, err := o.run(ctx, task)
+	if err == nil {
+		return res, nil
+	}
+
+	// Roll back even if the caller has given up on the task
+	undone, rbErr := backup.Restore(context.WithoutCancel(ctx))
+	res.RolledBack = undone
+	if rbErr != nil {
+		o.config.Logger.Error("rollback failed", "task_id", task.ID, "dir", dir, "reason", rbErr)
+		err = errors.Join(err, fmt.Errorf("roll back: %w", rbErr))
+	} else {
+		o.config.Logger.Info("rolled back failed task", "task_id", task.ID, "dir", dir)
+	}
+	return res, err
+}
+
+// runOnce spawns one agent for task and supervises it to the end,
+// checking its file changes if so configured.
+func (o *Orchestrator) runOnce(ctx context.Context, task Task) (*TaskResult, error) {
+	if !o.config.CheckFiles && !o.restricted(task) {
+		return o.supervise(ctx, task)
+	}
+
+	// Reverting needs the files' old content, not just their hashes
+	dir := cmp.Or(task.Repo, ".")
+	var snap *workspace.Snapshot
+	var backup *workspace.Backup
+	var err error
+	if o.config.PathPolicy == PathRevert && o.restricted(task) {
+		backup, err = workspace.Save(ctx, dir)
+		if err == nil {
+			defer backup.Discard()
+			snap = backup.Snapshot
+		}
+	} else {
+		snap, err = workspace.Take(ctx, dir)
+	}
+	if err != nil {
+		return &TaskResult{}, fmt.Errorf("snapshot %s: %w", dir, err)
+	}
+
+	res, err := o.supervise(ctx, task)
+
+	changes, snapErr := snap.Changes(context.WithoutCancel(ctx))
+	if snapErr != nil {
+		return res, errors.Join(err, fmt.Errorf("check files: %w", snapErr))
+	}
+	res.Files = o.fileReport(task, dir, changes, res.Complete)
+	if len(res.Files.Disallowed) == 0 {
+		return res, err
+	}
+	switch o.config.PathPolicy {
+	case PathFlag:
+	case PathRevert:
+		// Even if the agent was killed for it: the stray write is
+		// still there.
+		if rvErr := backup.RestoreFiles(context.WithoutCancel(ctx), res.Files.Disallowed); rvErr != nil {
+			return res, errors.Join(err, fmt.Errorf("revert disallowed changes: %w", rvErr))
+		}
+		res.Files.Reverted = res.Files.Disallowed
+		o.config.Logger.Info("reverted disallowed changes", "task_id", task.ID, "paths", res.Files.Reverted)
+	default:
+		if err == nil {
+			err = fmt.Errorf("%w: %s", ErrDisallowedPath, strings.Join(res.Files.Disallowed, ", "))
+		}
+	}
+	return res, err
+}
+
+// supervise spawns one agent for task and supervises it to the end.
+func (o *Orchestrator) supervise(ctx context.Context, task Task) (*TaskResult, error) {
+	res := &TaskResult{}
+
+	logger := o.config.Logger.With("task_id", task.ID)
+
+	var tr *transcript
+	if o.config.LogDir != "" {
+		t, err := openTranscript(o.config.LogDir, task.ID)
+		if err != nil {
+			return res, err
+		}
+		defer t.close()
+		tr = t
+	}
+
+	// Watch for edits from before the agent can make any. If the
+	// platform can't, the snapshot comparison in runOnce still catches
+	// stray writes, just not as they happen.
+	var fsCh <-chan fsEvent
+	if o.config.WatchFiles || o.config.WatchPaths && o.restricted(task) {
+		if w, err := watchTree(cmp.Or(task.Repo, ".")); err == nil {
+			defer w.stop()
+			fsCh = w.events
+		} else {
+			logger.Warn("file watcher unavailable", "reason", err)
+		}
+	}
+
 	// --- Phase 1: Spawn the process and wire pipes ---
-	cmd := exec.Command(o.config.AgentBin)
-	// Sets the agen'ts working directory
-	cmd.Dir = repo
 
-	stdinPipe, err := cmd.StdinPipe()
+	// Our own pipes rather than cmd.StdinPipe and friends, which only
+	// work for one Start - the agent may need two, see startInCgroup.
+	// For stdout it matters anyway: Wait closes cmd.StdoutPipe as soon
+	// as the agent exits, racing the reader for the last lines (usually
+	// the CompleteMessage). With our own pipe the reader drains
+	// everything up to EOF.
+	stdinR, stdinPipe, err := os.Pipe()
 	if err != nil {
-		return nil, fmt.Errorf("create stdin pipe: %w", err)
+		return res, fmt.Errorf("create stdin pipe: %w", err)
 	}
+	defer stdinPipe.Close()
 
-	stdoutPipe, err := cmd.StdoutPipe()
+	stdoutPipe, stdoutW, err := os.Pipe()
 	if err != nil {
-		return nil, fmt.Errorf("create stdout pipe: %w", err)
+		stdinR.Close()
+		return res, fmt.Errorf("create stdout pipe: %w", err)
 	}
+	defer stdoutPipe.Close()
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("start agent: %w", err)
+	// Same for stderr, so a crash report has all of it.
+	stderrPipe, stderrW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdoutW.Close()
+		return res, fmt.Errorf("create stderr pipe: %w", err)
+	}
+	defer stderrPipe.Close()
+
+	newCmd := func() *exec.Cmd {
+		cmd := exec.Command(o.config.AgentBin)
+		// Sets the agen'ts working directory
+		cmd.Dir = task.Repo
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = stdinR, stdoutW, stderrW
+		return cmd
+	}
+
+	// Put the agent in its own cgroup if we can. If not, the checks in
+	// the control loop are the only limits - same as without cgroups.
+	var cg *cgroup
+	if o.config.Cgroup != nil {
+		if c, err := newCgroup(o.config.Cgroup, task.ID); err == nil {
+			cg = c
+		} else {
+			logger.Warn("cgroup limits not applied, running unconfined", "reason", err)
+		}
 	}
 
-	// Ensure cleanup: if we return early for any reason, kill the process.
-	// This is the safety net - specific paths may kill it earlier.
-	// NOTE - defer runs in reverse order - Wait then Kill
+	// startProc puts the agent in its own process group
+	var proc *agentProc
+	if cg != nil {
+		var inCgroup bool
+		proc, inCgroup, err = startInCgroup(newCmd, cg)
+		if !inCgroup {
+			if err == nil {
+				logger.Warn("cgroup limits not applied, running unconfined", "cgroup", cg.path)
+			}
+			cg.remove()
+			cg = nil
+		}
+	} else {
+		proc, err = startProc(newCmd())
+	}
+	stdinR.Close() // the agent has its own copies now
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		return res, fmt.Errorf("start agent: %w", err)
+	}
+	cmd := proc.cmd
+	if cg != nil {
+		proc.cg = cg
+		res.Cgroup = cg.path
+	}
+
+	logger = logger.With("pid", cmd.Process.Pid)
+	logger.Info("agent started", "agent", o.config.AgentBin, "repo", task.Repo)
+
+	var stderrLog io.Writer
+	if tr != nil {
+		stderrLog = tr.stderr
+	}
+	stderr := captureStderr(stderrPipe, newRingBuffer(o.config.StderrTailBytes), stderrLog)
+
+	o.emit(TaskStarted{EventHeader: eventHeader(task), Task: task, PID: cmd.Process.Pid})
+
+	// Ensure cleanup: if we return early for any reason, kill the process
+	// and anything it spawned. This is the safety net - specific paths may
+	// kill it earlier. Whatever outlived an agent that exited on its own
+	// is reported as leaked.
 	defer func() {
-		cmd.Process.Kill()
-		cmd.Wait()
+		proc.kill()
+		res.ExitCode, res.Signal = proc.exitStatus()
+		res.WallTime = proc.wallTime()
+		res.LeakedPIDs = proc.leaked
+		if cg != nil {
+			cg.remove()
+		}
+		o.emit(Exited{EventHeader: eventHeader(task), ExitCode: res.ExitCode, Signal: res.Signal, WallTime: res.WallTime})
+		logger.Info("agent exited", "exit_code", res.ExitCode, "signal", res.Signal, "wall_time", res.WallTime)
 	}()
 
-	// --- Phase 2: Send init + task messages ---
+	// kill and terminate stop the agent for good - SIGKILL now, or
+	// SIGTERM and SIGKILL after TermGrace - and return reason, the
+	// error the task fails with. Observers hear about it unless the
+	// agent had exited already.
+	killed := func(reason error, how string) {
+		if !proc.exited {
+			logger.Warn("killing agent", "signal", how, "reason", reason)
+			o.emit(Killed{EventHeader: eventHeader(task), Reason: reason})
+		}
+	}
+	kill := func(reason error) error {
+		killed(reason, "SIGKILL")
+		proc.kill()
+		return reason
+	}
+	terminate := func(reason error) error {
+		killed(reason, "SIGTERM")
+		proc.terminate(o.config.TermGrace)
+		return reason
+	}
+
+	// --- Phase 2: Handshake, then send init + task messages ---
+	var stdin io.Writer = stdinPipe
+	var stdout io.Reader = stdoutPipe
+	if tr != nil {
+		stdin = io.MultiWriter(stdinPipe, tr.stdin)
+		stdout = io.TeeReader(stdoutPipe, tr.stdout)
+	}
+	enc := protocol.NewEncoder(stdin)
+	dec := protocol.NewDecoder(stdout)
+	dec.SetMaxMessageBytes(o.config.MaxMessageBytes)
+	dec.SetMaxChunkedBytes(o.config.MaxChunkedBytes)
+	res.ProtocolVersion = protocol.ProtocolVersion
+	if o.config.Handshake {
+		v, err := o.handshake(ctx, dec)
+		if err != nil {
+			logger.Error("handshake failed", "reason", err)
+			return res, kill(err)
+		}
+		enc.SetVersion(v)
+		dec.SetVersion(v)
+		res.ProtocolVersion = v
+	}
+	logger = logger.With("version", res.ProtocolVersion)
+
 	init := protocol.InitMessage{
-		Type: "init",
-		Version: protocol.ProtocolVersion,
 		HeartbeatIntervalS: int(o.config.HeartbeatTimeout.Seconds()) / 2,
-	}
-	if err := sendMessage(stdinPipe, init); err != nil {
-		return nil, fmt.Errorf("send init: %w", err)
+		MaxTokens: o.config.MaxTokens,
+		MaxTokensIn: o.config.MaxTokensIn,
+		MaxTokensOut: o.config.MaxTokensOut,
+		MaxDurationS: o.config.MaxDuration.Seconds(),
 	}
 
-	task := protocol.TaskMessage{
-		Type: "task",
-		Version: protocol.ProtocolVersion,
-		ID: taskID,
-		Prompt: prompt,
-		Repo: repo,
+	taskMsg := protocol.TaskMessage{
+		ID: task.ID,
+		Prompt: task.Prompt,
+		Repo: task.Repo,
+		DependsOn: task.DependsOn,
+		Context: task.Context,
+		Files: task.Files,
 	}
-	if err := sendMessage(stdinPipe, task); err != nil {
-		return nil, fmt.Errorf("send task: %w", err)
+
+	// Written from another goroutine: an agent that doesn't read its
+	// stdin would otherwise hang us on a big TaskMessage, deaf to ctx
+	// and the watchdog alike.
+	stopWriter := make(chan struct{})
+	defer close(stopWriter)
+	outbox, writeErrs := startWriter(enc, stopWriter)
+
+	// send queues msg for the agent. Returns false if the agent has
+	// stopped reading or fallen outboxSize messages behind.
+	stdinBroken := false
+	send := func(msg protocol.Message) bool {
+		if stdinBroken {
+			return false
+		}
+		select {
+		case outbox <- msg:
+			return true
+		default:
+			return false
+		}
 	}
+	send(init)
+	send(taskMsg)
+	logger.Debug("task queued")
 
 	// --- Phase 3: Monitor ---
 
-	// Track process exit in background
-	waitCh := make(chan error, 1)
-	go func() {
-		waitCh <- cmd.Wait()
-	}()
-
-	msgCh := startReader(stdoutPipe)
+	stopReader := make(chan struct{})
+	defer close(stopReader)
+	msgCh := startReader(dec, stopReader)
 
 	heartbeat := time.NewTimer(o.config.HeartbeatTimeout)
 	defer heartbeat.Stop()
 
+	// Measure RSS ourselves rather than trusting heartbeats. If the
+	// platform can't, the agent's own figures are all we have.
+	rssTicker := time.NewTicker(o.config.RSSSampleInterval)
+	defer rssTicker.Stop()
+
+	// The wall-clock budget runs from spawn, like WallTime.
+	var deadline <-chan time.Time
+	if o.config.MaxDuration > 0 {
+		t := time.NewTimer(o.config.MaxDuration - proc.elapsed())
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	// Files the watcher saw change since the last heartbeat
+	touched := make(map[string]bool)
+
+	// exitCh is nilled out once the process exits, so we keep draining
+	// stdout - the CompleteMessage may still be in flight. drainCh
+	// fires if stdout is still open stdoutDrainTimeout after that.
+	exitCh := proc.waitCh
+	var drainCh <-chan time.Time
+
+	// Cancellation state. doneCh fires once; after that we're waiting
+	// out the grace period for the agent's "cancelled" CompleteMessage.
+	doneCh := ctx.Done()
+	var cancelCause error
+	var graceCh <-chan time.Time
+
+	// beginCancel sends the CancelMessage and starts the grace timer.
+	// Returns false if the agent couldn't even be told.
+	beginCancel := func(cause error) bool {
+		logger.Info("cancelling agent", "reason", cause, "grace", o.config.CancelGrace)
+		doneCh = nil
+		cancelCause = cause
+		cancel := protocol.CancelMessage{
+			ID: task.ID,
+			Reason: cause.Error(),
+		}
+		if !send(cancel) {
+			return false
+		}
+		grace := time.NewTimer(o.config.CancelGrace)
+		graceCh = grace.C
+		return true
+	}
+
+	// Blocked state. While a BlockedHandler is deciding, the heartbeat
+	// watchdog is stopped: a blocked agent has nothing to report.
+	blockedCtx, stopBlocked := context.WithCancel(ctx)
+	defer stopBlocked()
+	var decisionCh <-chan BlockedDecision
+	var question string
+
+	// cancelTask drops any pending question and starts the cancel
+	// ladder. Returns false if the agent couldn't even be told.
+	cancelTask := func(cause error) bool {
+		stopBlocked()
+		decisionCh = nil
+		heartbeat.Reset(o.config.HeartbeatTimeout)
+		return beginCancel(cause)
+	}
+
+	// overBudget records a blown budget and asks the agent to stop.
+	// Returns false if the agent couldn't be told and has been
+	// terminated instead.
+	overBudget := func(err *BudgetError) bool {
+		res.ExceededBudget = err.Budget
+		logger.Warn("budget exceeded", "budget", err.Budget, "used", err.Used, "limit", err.Limit)
+		if cancelTask(err) {
+			return true
+		}
+		terminate(cancelledError(err))
+		return false
+	}
+
+	// exitedError explains an agent that exited without completing,
+	// waiting for the exit if need be.
+	exitedError := func() error {
+		err := proc.wait()
+		if cancelCause != nil {
+			return cancelledError(cancelCause)
+		}
+		if err != nil && cg != nil && cg.oomKilled() {
+			return stderr.wrap(fmt.Errorf(
+				"%w: %w: memory.max is %d MB", ErrCrashed, ErrOOMKilled, o.config.Cgroup.MemoryMaxMB,
+			))
+		}
+		if err != nil {
+			return stderr.wrap(fmt.Errorf("%w: %w", ErrCrashed, err))
+		}
+		return stderr.wrap(ErrExitedWithoutComplete)
+	}
+
+	// Budgets already warned about
+	warned := make(map[Budget]bool)
+
 	for {
 		select {
 		case result, ok := <-msgCh:
 			// Channel closed - reader goroutine exited
 			if !ok {
-				// Wait for process to exit so we can report the exit error
-				err := <-waitCh
-				if err != nil {
-					return nil, fmt.Errorf("agent exited unexpectedly: %w", err)
-				}
-				return nil, fmt.Errorf("agent exited without completing")
+				return res, exitedError()
 			}
 
 			// Parse error - agent sent garbage
 			if result.err != nil {
-				cmd.Process.Kill()
-				<-waitCh
-				return nil, fmt.Errorf("agent protocol error: %w ", err)
+				err := fmt.Errorf("%w: %w", ErrProtocol, result.err)
+				logger.Error("protocol error", "reason", err)
+				return res, kill(err)
 			}
+			o.emit(MessageReceived{EventHeader: eventHeader(task), Message: result.msg})
 
 			// Valid message - agent is alive, reset the watchdog
-			heartbeat.Reset(o.config.HeartbeatTimeout)
+			// (unless it's parked waiting for an answer)
+			if decisionCh == nil {
+				heartbeat.Reset(o.config.HeartbeatTimeout)
+			}
 
 			// Handle by type
 			switch msg := result.msg.(type) {
 			case *protocol.HeartbeatMessage:
-				// Check RSS budget
-				if o.config.MaxRSSMB > 0 && int(msg.RSSMB) > o.config.MaxRSSMB {
-					cmd.Process.Kill()
-					<-waitCh
-					return nil, fmt.Errorf(
-						"agent exceeeded RSS limit: %d MB > %d MB",
-						int(msg.RSSMB), o.config.MaxRSSMB,
-					)
+				if res.LastHeartbeat == nil || res.LastHeartbeat.State != msg.State {
+					logger.Info("agent state changed", "state", msg.State, "tool", msg.Tool, "detail", msg.Detail)
+				}
+				res.LastHeartbeat = msg
+				hb := HeartbeatReceived{EventHeader: eventHeader(task), Heartbeat: msg}
+				if fsCh != nil {
+					hb.FilesTouched = slices.Sorted(maps.Keys(touched))
+					clear(touched)
+				}
+				o.emit(hb)
+				res.ReportedRSSMB = max(res.ReportedRSSMB, msg.RSSMB)
+				// Check RSS budget. The sampler below is what keeps
+				// agents honest, but an agent admitting it's over
+				// budget is taken at its word.
+				if o.overRSS(msg.RSSMB) {
+					return res, kill(o.rssError(res))
+				}
+				// Counters are cumulative, so the latest figure is the
+				// running total - but never let a glitch lower it.
+				res.CostUSD = max(res.CostUSD, o.cost(msg.Model, msg.TokensIn, msg.TokensOut, msg.CostUSD))
+				if o.overCost(res.CostUSD) {
+					return res, kill(o.costError(res))
+				}
+				o.warnBudgets(task, warned, append(o.heartbeatUsage(msg),
+					budgetUsage{BudgetDuration, proc.elapsed().Seconds(), o.config.MaxDuration.Seconds()},
+					budgetUsage{BudgetCost, res.CostUSD, o.config.MaxCostUSD},
+					budgetUsage{BudgetRSS, msg.RSSMB, float64(o.config.MaxRSSMB)},
+				)...)
+				// Token and time budgets get the gentler treatment:
+				// the agent may be mid-edit, so let it wrap up.
+				if cancelCause == nil {
+					if err := o.checkBudgets(msg); err != nil && !overBudget(err) {
+						return res, cancelledError(err)
+					}
 				}
 				// Otherwise: agent is a live and within budget, continue
 
 			case *protocol.BlockedMessage:
-				// v0.0: nobody's home to answer. Kill and report
-				cmd.Process.Kill()
-				<-waitCh
-				return nil, fmt.Errorf(
-					"agent blocked with question: %s", msg.Question,
-				)
+				// One question at a time - an agent that asks again
+				// before hearing back just has to keep waiting.
+				if decisionCh != nil || cancelCause != nil {
+					break
+				}
+				heartbeat.Stop()
+				question = msg.Question
+				logger.Info("agent blocked", "question", msg.Question)
+				o.emit(Blocked{EventHeader: eventHeader(task), Question: msg.Question, Options: msg.Options})
+				decisionCh = askBlocked(blockedCtx, o.config.Blocked, task.ID, msg)
+
+			case *protocol.HelloMessage:
+				// Hello without a handshake: we've already picked a
+				// version, so all that's left is to check the agent
+				// can speak it.
+				if !slices.Contains(msg.Versions, res.ProtocolVersion) {
+					err := fmt.Errorf(
+						"%w: %w: agent speaks %v, we sent v%d",
+						ErrProtocol, protocol.ErrIncompatibleVersion, msg.Versions, res.ProtocolVersion,
+					)
+					logger.Error("protocol error", "reason", err)
+					return res, kill(err)
+				}
 
 			case *protocol.CompleteMessage:
-				// Happy path - agent finished its task
-				<-waitCh
-				return msg, nil
+				// Happy path - agent finished its task. If it lingers,
+				// it gets TermGrace to exit before it's stopped; the
+				// CompleteMessage stands either way.
+				if !proc.waitFor(o.config.TermGrace) {
+					terminate(fmt.Errorf("still running %s after completing", o.config.TermGrace))
+				}
+				logger.Info("agent completed", "state", msg.State)
+				res.Complete = msg
+				res.CostUSD = max(res.CostUSD, o.cost(msg.Model, msg.TokensIn, msg.TokensOut, msg.CostUSD))
+				// Finishing after a cancel - or a budget running out -
+				// doesn't undo it, whatever the agent says.
+				if cancelCause != nil {
+					return res, cancelledError(cancelCause)
+				}
+				return res, nil
 
 			default:
 				// Unknown message type from a well-parsed message.
 				// Shouldn't happen, but don't crash - log and continue.
+				logger.Warn("unexpected message", "type", msg.MessageType())
 			}
 
+		case d := <-decisionCh:
+			decisionCh = nil
+			switch d.Action {
+			case BlockedAnswer:
+				logger.Info("answering agent", "question", question)
+				answer := protocol.AnswerMessage{
+					ID: task.ID,
+					Response: d.Response,
+				}
+				if !send(answer) {
+					return res, kill(errors.New("send answer: agent isn't reading stdin"))
+				}
+				heartbeat.Reset(o.config.HeartbeatTimeout)
+
+			case BlockedCancel:
+				heartbeat.Reset(o.config.HeartbeatTimeout)
+				cause := fmt.Errorf("%w with question %q: %s", ErrBlocked, question, d.Reason)
+				if !beginCancel(cause) {
+					return res, terminate(cancelledError(cause))
+				}
+
+			default:
+				return res, kill(fmt.Errorf(
+					"%w with question %q: %s", ErrBlocked, question, d.Reason,
+				))
+			}
+
+		case err := <-writeErrs:
+			// Usually the agent died before it read everything, and
+			// how it died is what the task fails with. Its exit, or
+			// the watchdog, settles things from here.
+			logger.Warn("agent stopped reading stdin", "reason", err)
+			writeErrs = nil
+			stdinBroken = true
+
+		case <-rssTicker.C:
+			if proc.exited {
+				break
+			}
+			mb, err := processTreeRSS(proc.cmd.Process.Pid)
+			if err != nil {
+				// Can't see /proc (or the agent just exited) - stop
+				// sampling and fall back to heartbeats
+				rssTicker.Stop()
+				break
+			}
+			res.PeakRSSMB = max(res.PeakRSSMB, mb)
+			if o.overRSS(mb) {
+				return res, kill(o.rssError(res))
+			}
+			o.warnBudgets(task, warned, budgetUsage{BudgetRSS, mb, float64(o.config.MaxRSSMB)})
+
+		case ev, ok := <-fsCh:
+			if !ok {
+				fsCh = nil
+				break
+			}
+			if o.config.WatchPaths && !o.allowed(task, ev.path) {
+				return res, kill(fmt.Errorf("%w: %s, while running", ErrDisallowedPath, ev.path))
+			}
+			touched[ev.path] = true
+			o.emit(FileChanged{EventHeader: eventHeader(task), Path: ev.path, Op: ev.op})
+
 		case <-heartbeat.C:
 			// Agent went silent. Kill it.
-			cmd.Process.Kill()
-			<-waitCh
-			return nil, fmt.Errorf(
-				"agent heartbeat timeout after %s", o.config.HeartbeatTimeout,
-			)
+			if cancelCause != nil {
+				return res, kill(cancelledError(cancelCause))
+			}
+			// Wrapped after the kill, which is what ends its stderr
+			err := kill(fmt.Errorf("%w after %s", ErrHeartbeatTimeout, o.config.HeartbeatTimeout))
+			return res, stderr.wrap(err)
 
-		case err := <-waitCh:
-			// Process exited before sending CompleteMessage
-			if err != nil {
-				return nil, fmt.Errorf("agent crashed: %w", err)
+		case err := <-exitCh:
+			// Process exited. Don't decide anything yet: the reader
+			// closes msgCh once it has drained whatever was written.
+			proc.observeExit(err)
+			exitCh = nil
+			drain := time.NewTimer(stdoutDrainTimeout)
+			defer drain.Stop()
+			drainCh = drain.C
+
+		case <-drainCh:
+			// Something the agent started still holds stdout open.
+			// Whatever the agent itself wrote has been read by now;
+			// the deferred kill takes care of the rest.
+			logger.Warn("stdout still open after agent exit", "leaked", proc.leaked)
+			return res, exitedError()
+
+		case <-deadline:
+			deadline = nil
+			if cancelCause != nil {
+				break
+			}
+			err := o.durationError(proc.elapsed())
+			if !overBudget(err) {
+				return res, cancelledError(err)
+			}
+
+		case <-doneCh:
+			// Caller gave up. Ask the agent to wrap up; if we can't
+			// even tell it, go straight to signals.
+			cause := context.Cause(ctx)
+			if !cancelTask(cause) {
+				return res, terminate(cancelledError(cause))
 			}
-			return nil, fmt.Errorf("agent exited without completing")
+
+		case <-graceCh:
+			// Agent ignored the cancel. Escalate.
+			return res, terminate(cancelledError(cancelCause))
 		}
 	}
+}
+
+// handshake waits for the agent's HelloMessage and negotiates the
+// protocol version. Agents that never say hello fail after
+// HeartbeatTimeout, like any other silent agent.
+func (o *Orchestrator) handshake(ctx context.Context, dec *protocol.Decoder) (int, error) {
+	type first struct {
+		msg protocol.Message
+		err error
+	}
+	ch := make(chan first, 1)
+	go func() {
+		msg, err := dec.Decode()
+		ch <- first{msg, err}
+	}()
+
+	timer := time.NewTimer(o.config.HeartbeatTimeout)
+	defer timer.Stop()
+
+	select {
+	case f := <-ch:
+		if f.err == io.EOF {
+			return 0, fmt.Errorf("%w: never said hello", ErrExitedWithoutComplete)
+		}
+		if f.err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrProtocol, f.err)
+		}
+		hello, ok := f.msg.(*protocol.HelloMessage)
+		if !ok {
+			return 0, fmt.Errorf(
+				"%w: expected hello, got %s", ErrProtocol, f.msg.MessageType(),
+			)
+		}
+		v, err := protocol.Negotiate(hello.Versions)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrProtocol, err)
+		}
+		return v, nil
+
+	case <-timer.C:
+		return 0, fmt.Errorf("%w: no hello within %s", ErrHeartbeatTimeout, o.config.HeartbeatTimeout)
+
+	case <-ctx.Done():
+		return 0, cancelledError(context.Cause(ctx))
+	}
+}
+
+// overRSS reports whether mb is over the RSS budget.
+func (o *Orchestrator) overRSS(mb float64) bool {
+	return o.config.MaxRSSMB > 0 && mb > float64(o.config.MaxRSSMB)
+}
+
+// rssError describes an RSS kill with both the measured and the
+// self-reported figures.
+func (o *Orchestrator) rssError(res *TaskResult) error {
+	return fmt.Errorf(
+		"%w: measured %.1f MB, reported %.1f MB, limit %d MB",
+		ErrRSSExceeded, res.PeakRSSMB, res.ReportedRSSMB, o.config.MaxRSSMB,
+	)
+}
 
-	return nil, fmt.Errorf("not yet implemented")
+// cancelledError wraps ErrCancelled with the reason the task was cancelled.
+func cancelledError(cause error) error {
+	return fmt.Errorf("%w: %w", ErrCancelled, cause)
 }
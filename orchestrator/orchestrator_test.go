@@ -1,12 +1,22 @@
 package orchestrator
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/tparlmer/leopold/protocol"
 )
 
 // agentBin returns the path to a compiled fake agent binary.
@@ -20,7 +30,7 @@ func agentBin(name string) string {
 
 func TestMain(m *testing.M) {
 	// Build all fake agents before tests run
-	agents := []string{"happy", "hang", "crash", "leak", "garbage"}
+	agents := []string{"happy", "hang", "crash", "leak", "garbage", "polite", "blocked", "liar", "spawner", "hello", "future", "bigdiff", "echo"}
 	for _, a := range agents {
 		cmd := exec.Command("go", "build", "-o",
 			filepath.Join("testdata", "bin", a),
@@ -62,8 +72,12 @@ func TestOrchestratorKillsAgentOnHeartbeatTimeout(t *testing.T) {
 	})
 
 	_, err := orch.RunTask("test-3", "do the thing", t.TempDir())
-	if err == nil {
-		t.Fatal("expected error for crashed agent, got nil")
+	if !errors.Is(err, ErrHeartbeatTimeout) {
+		t.Fatalf("err = %v, want ErrHeartbeatTimeout", err)
+	}
+	var serr *StderrError
+	if !errors.As(err, &serr) || !strings.Contains(serr.Stderr, "never happen") {
+		t.Errorf("err = %v, want the agent's stderr attached", err)
 	}
 }
 
@@ -75,8 +89,29 @@ func TestOrchestratorHandlesAgentCrash(t *testing.T) {
 	})
 
 	_, err := orch.RunTask("test-3", "do the thing", t.TempDir())
-	if err == nil {
-		t.Fatal("expected error for crashed agent, got nil")
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("err = %v, want ErrCrashed", err)
+	}
+	var serr *StderrError
+	if !errors.As(err, &serr) || serr.Stderr != "panic: agent fell over\n" {
+		t.Errorf("err = %v, want the agent's stderr attached", err)
+	}
+}
+
+func TestOrchestratorHandlesCrashBeforeReadingTask(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("crash"), // never reads stdin
+		HeartbeatTimeout: 5 * time.Second,
+	})
+
+	// Bigger than the pipe buffer, so sending it always fails
+	_, err := orch.RunTask("test-35", strings.Repeat("x", 300<<10), t.TempDir())
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("err = %v, want ErrCrashed", err)
+	}
+	var serr *StderrError
+	if !errors.As(err, &serr) || serr.Stderr != "panic: agent fell over\n" {
+		t.Errorf("err = %v, want the agent's stderr attached", err)
 	}
 }
 
@@ -88,8 +123,8 @@ func TestOrchestratorKillsAgentExceedingRSS(t *testing.T) {
 	})
 
 	_, err := orch.RunTask("test-4", "do the thing", t.TempDir())
-	if err == nil {
-		t.Fatal("expected error for RSS-exceeded agent, got nil")
+	if !errors.Is(err, ErrRSSExceeded) {
+		t.Fatalf("err = %v, want ErrRSSExceeded", err)
 	}
 }
 
@@ -101,7 +136,619 @@ func TestOrchestratorHandlesMalformedJSON(t *testing.T) {
 	})
 
 	_, err := orch.RunTask("test-5", "do the thing", t.TempDir())
-	if err == nil {
-		t.Fatal("expected error for garbage-output agent, got nil")
+	if !errors.Is(err, ErrProtocol) {
+		t.Fatalf("err = %v, want ErrProtocol", err)
+	}
+}
+
+func TestOrchestratorCancelsAgentGracefully(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("polite"),
+		HeartbeatTimeout: 5 * time.Second,
+		CancelGrace: 2 * time.Second,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	result, err := orch.RunTaskContext(ctx, "test-6", "do the thing", t.TempDir())
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want it to carry the context's cause", err)
+	}
+	if result == nil {
+		t.Fatal("expected the agent's cancelled CompleteMessage, got nil")
+	}
+	if result.State != "cancelled" {
+		t.Errorf("state = %q, want %q", result.State, "cancelled")
+	}
+}
+
+func TestOrchestratorCancelSurvivesDoneCompletion(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("polite"),
+		HeartbeatTimeout: 5 * time.Second,
+		CancelGrace: 2 * time.Second,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	result, err := orch.RunTaskContext(ctx, "test-31", "finish anyway", t.TempDir())
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+	if result == nil || result.State != "done" {
+		t.Errorf("result = %+v, want the agent's own \"done\"", result)
+	}
+}
+
+func TestOrchestratorEscalatesWhenAgentIgnoresCancel(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("hang"),
+		HeartbeatTimeout: 10 * time.Second,
+		CancelGrace: 200 * time.Millisecond,
+		TermGrace: 200 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+	result, err := orch.RunTaskContext(ctx, "test-7", "do the thing", t.TempDir())
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+	if result != nil {
+		t.Errorf("result = %+v, want nil for a killed agent", result)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancellation took %s, want well under the heartbeat timeout", elapsed)
+	}
+}
+
+func TestOrchestratorCancelsAgentThatIsNotReading(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("hang"), // reads at most 64 KiB of its task
+		HeartbeatTimeout: 10 * time.Second,
+		CancelGrace: 200 * time.Millisecond,
+		TermGrace: 200 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := orch.RunTaskContext(ctx, "test-33", strings.Repeat("x", 300<<10), t.TempDir())
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancellation took %s, want well under the heartbeat timeout", elapsed)
+	}
+}
+
+func TestOrchestratorAnswersBlockedAgent(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("blocked"),
+		HeartbeatTimeout: 5 * time.Second,
+		Blocked: AutoPick(1),
+	})
+
+	result, err := orch.RunTask("test-8", "do the thing", t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Summary != "B" {
+		t.Errorf("summary = %q, want the auto-picked option %q", result.Summary, "B")
+	}
+}
+
+func TestOrchestratorFailsBlockedAgentByDefault(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("blocked"),
+		HeartbeatTimeout: 5 * time.Second,
+	})
+
+	_, err := orch.RunTask("test-9", "do the thing", t.TempDir())
+	if !errors.Is(err, ErrBlocked) {
+		t.Fatalf("err = %v, want ErrBlocked", err)
+	}
+}
+
+func TestOrchestratorPausesWatchdogWhileHumanDecides(t *testing.T) {
+	queue := make(chan *Question)
+	orch := New(Config{
+		AgentBin: agentBin("blocked"),
+		HeartbeatTimeout: 200 * time.Millisecond, // agent is silent while blocked
+		Blocked: QueueForHuman(queue),
+	})
+
+	go func() {
+		q := <-queue
+		// Take several heartbeat timeouts to think it over
+		time.Sleep(600 * time.Millisecond)
+		q.Answer("yes, " + q.Options[2])
+	}()
+
+	result, err := orch.RunTask("test-10", "do the thing", t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Summary != "yes, C" {
+		t.Errorf("summary = %q, want %q", result.Summary, "yes, C")
+	}
+}
+
+func TestOrchestratorCancelsBlockedAgentOnHandlerRequest(t *testing.T) {
+	queue := make(chan *Question, 1)
+	orch := New(Config{
+		AgentBin: agentBin("blocked"),
+		HeartbeatTimeout: 5 * time.Second,
+		Blocked: QueueForHuman(queue),
+	})
+
+	go func() {
+		(<-queue).Cancel("changed my mind")
+	}()
+
+	result, err := orch.RunTask("test-11", "do the thing", t.TempDir())
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, ErrBlocked) {
+		t.Fatalf("err = %v, want ErrCancelled caused by ErrBlocked", err)
+	}
+	if result == nil || result.State != "cancelled" {
+		t.Errorf("result = %+v, want a cancelled CompleteMessage", result)
+	}
+}
+
+func TestDeferWithoutRetryAfterWaitsTheDefault(t *testing.T) {
+	var calls atomic.Int32
+	h := BlockedHandlerFunc(func(ctx context.Context, taskID string, msg *protocol.BlockedMessage) BlockedDecision {
+		if calls.Add(1) > 1 {
+			return BlockedDecision{Action: BlockedAnswer, Response: "now"}
+		}
+		return BlockedDecision{Action: BlockedDefer}
+	})
+
+	start := time.Now()
+	d := <-askBlocked(context.Background(), h, "test-32", &protocol.BlockedMessage{Question: "?"})
+	if d.Action != BlockedAnswer || calls.Load() != 2 {
+		t.Fatalf("decision %+v after %d calls, want an answer on the second", d, calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed < DefaultBlockedRetryAfter {
+		t.Errorf("asked again after %s, want at least %s", elapsed, DefaultBlockedRetryAfter)
+	}
+}
+
+func TestOrchestratorMeasuresRSSInsteadOfTrustingAgent(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("RSS measurement needs /proc")
+	}
+	orch := New(Config{
+		AgentBin: agentBin("liar"),
+		HeartbeatTimeout: 10 * time.Second,
+		MaxRSSMB: 50,
+		RSSSampleInterval: 20 * time.Millisecond,
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-12", Prompt: "do the thing", Repo: t.TempDir()})
+	if !errors.Is(err, ErrRSSExceeded) {
+		t.Fatalf("err = %v, want ErrRSSExceeded", err)
+	}
+	if res.PeakRSSMB <= 50 {
+		t.Errorf("PeakRSSMB = %.1f, want over the 50 MB limit", res.PeakRSSMB)
+	}
+	if res.ReportedRSSMB != 1 {
+		t.Errorf("ReportedRSSMB = %.1f, want the agent's claimed 1 MB", res.ReportedRSSMB)
+	}
+}
+
+func TestOrchestratorReportsMeasuredAndReportedRSS(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("RSS measurement needs /proc")
+	}
+	orch := New(Config{
+		AgentBin: agentBin("happy"),
+		HeartbeatTimeout: 5 * time.Second,
+		RSSSampleInterval: 10 * time.Millisecond,
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-13", Prompt: "do the thing", Repo: t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.PeakRSSMB <= 0 {
+		t.Errorf("PeakRSSMB = %.1f, want a measurement", res.PeakRSSMB)
+	}
+	if res.ReportedRSSMB != 12 {
+		t.Errorf("ReportedRSSMB = %.1f, want the highest heartbeat figure 12", res.ReportedRSSMB)
+	}
+}
+
+func TestNegativeRSSSampleIntervalMeansDefault(t *testing.T) {
+	orch := New(Config{RSSSampleInterval: -time.Second})
+	if got := orch.config.RSSSampleInterval; got != DefaultRSSSampleInterval {
+		t.Errorf("RSSSampleInterval = %s, want %s", got, DefaultRSSSampleInterval)
+	}
+}
+
+// spawnedChild reads the PID the spawner agent wrote for its child.
+func spawnedChild(t *testing.T, dir string) int {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "child.pid"))
+	if err != nil {
+		t.Fatalf("read child.pid: %v", err)
+	}
+	pid, err := strconv.Atoi(string(data))
+	if err != nil {
+		t.Fatalf("parse child.pid: %v", err)
+	}
+	return pid
+}
+
+// waitGone waits for pid to disappear, failing the test if it doesn't.
+func waitGone(t *testing.T, pid int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for signalProcess(pid, 0) == nil {
+		// Killed orphans are reaped by init; a zombie still answers
+		if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil && bytes.Contains(data, []byte(") Z ")) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pid %d still alive", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOrchestratorReportsAndKillsLeakedProcesses(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("leak detection needs /proc")
+	}
+	orch := New(Config{
+		AgentBin: agentBin("spawner"),
+		HeartbeatTimeout: 5 * time.Second,
+	})
+
+	dir := t.TempDir()
+	res, err := orch.Run(context.Background(), Task{ID: "test-16", Prompt: "do the thing", Repo: dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	child := spawnedChild(t, dir)
+	if len(res.LeakedPIDs) != 1 || res.LeakedPIDs[0] != child {
+		t.Errorf("LeakedPIDs = %v, want [%d]", res.LeakedPIDs, child)
+	}
+	waitGone(t, child)
+}
+
+func TestOrchestratorKillsWholeProcessGroupOnTimeout(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("spawner"),
+		HeartbeatTimeout: 300 * time.Millisecond,
+	})
+
+	dir := t.TempDir()
+	res, err := orch.Run(context.Background(), Task{ID: "test-17", Prompt: "hang", Repo: dir})
+	if !errors.Is(err, ErrHeartbeatTimeout) {
+		t.Fatalf("err = %v, want ErrHeartbeatTimeout", err)
+	}
+	if len(res.LeakedPIDs) != 0 {
+		t.Errorf("LeakedPIDs = %v, want none: the group was killed with the agent", res.LeakedPIDs)
+	}
+	waitGone(t, spawnedChild(t, dir))
+}
+
+func TestOrchestratorReportsCrashWhileChildHoldsStdout(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("spawner"),
+		HeartbeatTimeout: 3 * time.Second,
+	})
+
+	dir := t.TempDir()
+	start := time.Now()
+	res, err := orch.Run(context.Background(), Task{ID: "test-30", Prompt: "crash", Repo: dir})
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("err = %v, want ErrCrashed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s, want well under the heartbeat timeout", elapsed)
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want the agent's own 3", res.ExitCode)
+	}
+	waitGone(t, spawnedChild(t, dir))
+}
+
+func TestOrchestratorStopsAgentLingeringAfterComplete(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 10 * time.Second,
+		TermGrace: 200 * time.Millisecond,
+	})
+
+	start := time.Now()
+	res, err := orch.Run(context.Background(), Task{ID: "test-34", Prompt: "linger", Repo: t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Complete == nil || res.Complete.State != "done" {
+		t.Errorf("Complete = %+v, want the agent's \"done\"", res.Complete)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("took %s, want the agent stopped after TermGrace", elapsed)
+	}
+	if runtime.GOOS != "windows" && res.Signal != syscall.SIGTERM {
+		t.Errorf("signal %v, want SIGTERM", res.Signal)
+	}
+}
+
+func TestOrchestratorNegotiatesProtocolVersion(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("hello"),
+		HeartbeatTimeout: 5 * time.Second,
+		Handshake: true,
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-18", Prompt: "do the thing", Repo: t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ProtocolVersion != protocol.ProtocolVersion {
+		t.Errorf("ProtocolVersion = %d, want %d", res.ProtocolVersion, protocol.ProtocolVersion)
+	}
+}
+
+func TestOrchestratorRejectsIncompatibleAgent(t *testing.T) {
+	for _, handshake := range []bool{true, false} {
+		t.Run(fmt.Sprintf("handshake=%v", handshake), func(t *testing.T) {
+			orch := New(Config{
+				AgentBin: agentBin("future"),
+				HeartbeatTimeout: 5 * time.Second,
+				Handshake: handshake,
+			})
+
+			_, err := orch.RunTask("test-19", "do the thing", t.TempDir())
+			if !errors.Is(err, ErrProtocol) || !errors.Is(err, protocol.ErrIncompatibleVersion) {
+				t.Fatalf("err = %v, want ErrIncompatibleVersion", err)
+			}
+		})
+	}
+}
+
+func TestOrchestratorHandshakeRequiresHello(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("happy"), // never says hello
+		HeartbeatTimeout: 300 * time.Millisecond,
+		Handshake: true,
+	})
+
+	if _, err := orch.RunTask("test-20", "do the thing", t.TempDir()); !errors.Is(err, ErrHeartbeatTimeout) {
+		t.Fatalf("err = %v, want ErrHeartbeatTimeout", err)
+	}
+}
+
+func TestOrchestratorAcceptsLargeComplete(t *testing.T) {
+	for _, prompt := range []string{"one line", "chunked"} {
+		t.Run(prompt, func(t *testing.T) {
+			orch := New(Config{
+				AgentBin: agentBin("bigdiff"),
+				HeartbeatTimeout: 5 * time.Second,
+				MaxMessageBytes: 256 << 10,
+			})
+
+			result, err := orch.RunTask("test-21", prompt, t.TempDir())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result.Summary) < 200<<10-17 {
+				t.Errorf("summary has %d bytes, want about 200 KB", len(result.Summary))
+			}
+		})
+	}
+}
+
+func TestOrchestratorRejectsOversizedLine(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("bigdiff"),
+		HeartbeatTimeout: 5 * time.Second,
+		MaxMessageBytes: 64 << 10,
+	})
+
+	_, err := orch.RunTask("test-22", "one line", t.TempDir())
+	if !errors.Is(err, protocol.ErrMessageTooLarge) {
+		t.Fatalf("err = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestOrchestratorWritesTranscripts(t *testing.T) {
+	logDir := t.TempDir()
+	orch := New(Config{
+		AgentBin: agentBin("crash"),
+		HeartbeatTimeout: 5 * time.Second,
+		LogDir: logDir,
+	})
+	orch.RunTask("test/23", "do the thing", t.TempDir())
+
+	orch.config.AgentBin = agentBin("happy")
+	if _, err := orch.RunTask("test/23", "do the thing", t.TempDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Both runs land in the same, sanitized directory
+	dir := filepath.Join(logDir, "test_23")
+	for file, want := range map[string][]string{
+		"stdin.log": {`"type":"init"`, `"type":"task"`, `"type":"init"`, `"type":"task"`},
+		"stdout.log": {`"type":"heartbeat"`, `"type":"heartbeat"`, `"type":"complete"`},
+		"stderr.log": {"panic: agent fell over"},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(lines) != len(want) {
+			t.Fatalf("%s has %d lines, want %d:\n%s", file, len(lines), len(want), data)
+		}
+		for i, line := range lines {
+			stamp, rest, _ := strings.Cut(line, " ")
+			if _, err := time.Parse(time.RFC3339Nano, stamp); err != nil {
+				t.Errorf("%s line %d: bad timestamp: %v", file, i, err)
+			}
+			if !strings.Contains(rest, want[i]) {
+				t.Errorf("%s line %d = %q, want it to contain %q", file, i, rest, want[i])
+			}
+		}
+	}
+}
+
+func TestOrchestratorReportsExitStatus(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("crash"),
+		HeartbeatTimeout: 5 * time.Second,
+	})
+	res, err := orch.Run(context.Background(), Task{ID: "test-24", Prompt: "do the thing", Repo: t.TempDir()})
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("err = %v, want ErrCrashed", err)
+	}
+	if res.ExitCode != 1 || res.Signal != nil {
+		t.Errorf("exit code %d, signal %v; want 1, nil", res.ExitCode, res.Signal)
+	}
+	if res.WallTime <= 0 {
+		t.Errorf("WallTime = %s, want > 0", res.WallTime)
+	}
+
+	orch.config.AgentBin = agentBin("hang")
+	orch.config.HeartbeatTimeout = 300 * time.Millisecond
+	res, err = orch.Run(context.Background(), Task{ID: "test-24", Prompt: "do the thing", Repo: t.TempDir()})
+	if !errors.Is(err, ErrHeartbeatTimeout) {
+		t.Fatalf("err = %v, want ErrHeartbeatTimeout", err)
+	}
+	if res.LastHeartbeat == nil || res.LastHeartbeat.Detail != "starting" {
+		t.Errorf("LastHeartbeat = %+v, want the hang agent's only heartbeat", res.LastHeartbeat)
+	}
+	if runtime.GOOS != "windows" && (res.ExitCode != -1 || res.Signal != syscall.SIGKILL) {
+		t.Errorf("exit code %d, signal %v; want -1, SIGKILL", res.ExitCode, res.Signal)
+	}
+	if res.WallTime < orch.config.HeartbeatTimeout {
+		t.Errorf("WallTime = %s, want at least the heartbeat timeout", res.WallTime)
+	}
+}
+
+func TestOrchestratorCancelsAgentOverTokenBudget(t *testing.T) {
+	logDir := t.TempDir()
+	orch := New(Config{
+		AgentBin: agentBin("polite"), // 100 tokens in, 10 out per heartbeat
+		HeartbeatTimeout: 5 * time.Second,
+		MaxTokensIn: 10000,
+		MaxTokensOut: 30,
+		MaxTokens: 5000,
+		LogDir: logDir,
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-25", Prompt: "do the thing", Repo: t.TempDir()})
+	if !errors.Is(err, ErrBudgetExceeded) || !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrBudgetExceeded and ErrCancelled", err)
+	}
+	var berr *BudgetError
+	if !errors.As(err, &berr) || berr.Used != 40 || berr.Limit != 30 {
+		t.Errorf("err = %v, want 40 output tokens over a limit of 30", err)
+	}
+	if res.ExceededBudget != BudgetTokensOut {
+		t.Errorf("ExceededBudget = %q, want %q", res.ExceededBudget, BudgetTokensOut)
+	}
+	if res.Complete == nil || res.Complete.State != "cancelled" {
+		t.Errorf("Complete = %+v, want the agent's graceful cancellation", res.Complete)
+	}
+
+	// The agent was told its budgets up front
+	stdin, err := os.ReadFile(filepath.Join(logDir, "test-25", "stdin.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stdin), `"max_tokens":5000,"max_tokens_in":10000,"max_tokens_out":30`) {
+		t.Errorf("init message doesn't carry the budgets:\n%s", stdin)
+	}
+}
+
+func TestOrchestratorBudgetErrorSurvivesDoneCompletion(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("polite"),
+		HeartbeatTimeout: 5 * time.Second,
+		MaxTokensOut: 30,
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-29", Prompt: "finish anyway", Repo: t.TempDir()})
+	if !errors.Is(err, ErrBudgetExceeded) || !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrBudgetExceeded and ErrCancelled", err)
+	}
+	if res.ExceededBudget != BudgetTokensOut {
+		t.Errorf("ExceededBudget = %q, want %q", res.ExceededBudget, BudgetTokensOut)
+	}
+	if res.Complete == nil || res.Complete.State != "done" {
+		t.Errorf("Complete = %+v, want the agent's own \"done\"", res.Complete)
+	}
+}
+
+func TestOrchestratorStopsAgentOverTimeBudget(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("hang"), // never reports, ignores cancel
+		HeartbeatTimeout: 5 * time.Second,
+		MaxDuration: 300 * time.Millisecond,
+		CancelGrace: 100 * time.Millisecond,
+		TermGrace: 100 * time.Millisecond,
+	})
+
+	start := time.Now()
+	res, err := orch.Run(context.Background(), Task{ID: "test-26", Prompt: "do the thing", Repo: t.TempDir()})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("err = %v, want ErrBudgetExceeded", err)
+	}
+	if res.ExceededBudget != BudgetDuration {
+		t.Errorf("ExceededBudget = %q, want %q", res.ExceededBudget, BudgetDuration)
+	}
+	if res.Complete != nil {
+		t.Errorf("Complete = %+v, want nil for an agent that had to be killed", res.Complete)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s, want the budget plus grace periods", elapsed)
+	}
+}
+
+func TestOrchestratorTracksCost(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("happy"),
+		HeartbeatTimeout: 5 * time.Second,
+		// Would price the run at $1.80 - but the agent knows better
+		Pricing: map[string]Price{"fake-model": {InputUSD: 0.001, OutputUSD: 0.002}},
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-27", Prompt: "do the thing", Repo: t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.CostUSD != 0.25 {
+		t.Errorf("CostUSD = %g, want the agent's own 0.25", res.CostUSD)
+	}
+}
+
+func TestOrchestratorKillsAgentOverCostBudget(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("polite"), // 100 tokens in, 10 out per heartbeat, no cost reported
+		HeartbeatTimeout: 5 * time.Second,
+		Pricing: map[string]Price{"": {InputUSD: 0.01, OutputUSD: 0.1}}, // $2 per heartbeat
+		MaxCostUSD: 5,
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "test-28", Prompt: "do the thing", Repo: t.TempDir()})
+	if !errors.Is(err, ErrCostExceeded) {
+		t.Fatalf("err = %v, want ErrCostExceeded", err)
+	}
+	if res.CostUSD < 5 || res.CostUSD > 7 {
+		t.Errorf("CostUSD = %g, want the $6 that broke the budget", res.CostUSD)
 	}
 }
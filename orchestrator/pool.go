@@ -0,0 +1,221 @@
+package orchestrator
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Pool runs tasks on an Orchestrator, at most size agents at a time.
+// Tasks wait in a queue ordered by Task.Priority, highest first, and in
+// submission order among equals - with every priority left at zero, the
+// queue is plain FIFO.
+type Pool struct {
+	orch *Orchestrator
+	size int
+
+	// stop cancels every in-flight task when Shutdown runs out of
+	// patience. Tasks see it through their own context, so agents get
+	// a CancelMessage like any other cancellation.
+	stopCtx context.Context
+	stop    context.CancelCauseFunc
+
+	mu      sync.Mutex
+	idle    *sync.Cond // broadcast whenever running or the queue shrinks
+	queue   taskQueue
+	seq     uint64
+	running int
+	closed  bool
+}
+
+// NewPool returns a Pool that runs up to size tasks at once on orch.
+// A size below 1 is treated as 1.
+func NewPool(orch *Orchestrator, size int) *Pool {
+	stopCtx, stop := context.WithCancelCause(context.Background())
+	p := &Pool{orch: orch, size: max(size, 1), stopCtx: stopCtx, stop: stop}
+	p.idle = sync.NewCond(&p.mu)
+	return p
+}
+
+// Future is the eventual outcome of a submitted task.
+type Future struct {
+	Task Task
+
+	done chan struct{}
+	res  *TaskResult
+	err  error
+}
+
+// Done is closed once the task has finished, successfully or not.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the task has finished and returns what Run
+// returned for it. The TaskResult is never nil.
+func (f *Future) Wait() (*TaskResult, error) {
+	<-f.done
+	return f.res, f.err
+}
+
+func (f *Future) resolve(res *TaskResult, err error) {
+	f.res, f.err = res, err
+	close(f.done)
+}
+
+// Submit queues task and returns its Future. ctx governs the task from
+// now on: cancelled while queued, the task leaves the queue and its
+// Future resolves with ErrCancelled straight away; cancelled while
+// running, its agent is cancelled as with Run. Submit fails with
+// ErrPoolClosed once Shutdown has been called.
+func (p *Pool) Submit(ctx context.Context, task Task) (*Future, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	fut := &Future{Task: task, done: make(chan struct{})}
+	q := &queuedTask{ctx: ctx, fut: fut, seq: p.seq}
+	heap.Push(&p.queue, q)
+	p.seq++
+	q.stop = context.AfterFunc(ctx, func() { p.dequeue(q) })
+	p.dispatch()
+	return fut, nil
+}
+
+// dequeue drops q, given up on while it waited, from the queue.
+func (p *Pool) dequeue(q *queuedTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if q.index < 0 {
+		return // already started or failed
+	}
+	heap.Remove(&p.queue, q.index)
+	q.fut.resolve(&TaskResult{}, cancelledError(context.Cause(q.ctx)))
+	p.idle.Broadcast()
+}
+
+// Stats reports how many tasks are running and how many are queued.
+func (p *Pool) Stats() (running, queued int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running, p.queue.Len()
+}
+
+// Wait blocks until every submitted task has finished.
+func (p *Pool) Wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.running > 0 || p.queue.Len() > 0 {
+		p.idle.Wait()
+	}
+}
+
+// Shutdown stops the pool. New submissions are refused and queued
+// tasks fail with ErrPoolClosed without starting. Tasks already running
+// are left to finish until ctx is done; then their agents are cancelled
+// and Shutdown waits for them to wrap up, returning ctx's error.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	for p.queue.Len() > 0 {
+		q := heap.Pop(&p.queue).(*queuedTask)
+		q.stop()
+		q.fut.resolve(&TaskResult{}, ErrPoolClosed)
+	}
+	p.idle.Broadcast()
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		p.stop(ErrPoolClosed)
+		return nil
+	case <-ctx.Done():
+		p.stop(ErrPoolClosed)
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// dispatch starts queued tasks while there are free slots. Must be
+// called with p.mu held.
+func (p *Pool) dispatch() {
+	for p.running < p.size && p.queue.Len() > 0 {
+		q := heap.Pop(&p.queue).(*queuedTask)
+		q.stop()
+		if err := q.ctx.Err(); err != nil {
+			// Given up on while it waited - no point spawning it
+			q.fut.resolve(&TaskResult{}, cancelledError(context.Cause(q.ctx)))
+			continue
+		}
+		p.running++
+		go p.run(q)
+	}
+	p.idle.Broadcast()
+}
+
+// run executes one task and hands its slot to the next in the queue.
+func (p *Pool) run(q *queuedTask) {
+	ctx, cancel := context.WithCancelCause(q.ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(p.stopCtx, func() {
+		cancel(context.Cause(p.stopCtx))
+	})
+	defer stop()
+
+	q.fut.resolve(p.orch.Run(ctx, q.fut.Task))
+
+	p.mu.Lock()
+	p.running--
+	p.dispatch()
+	p.mu.Unlock()
+}
+
+// queuedTask is a task waiting for a slot in the pool.
+type queuedTask struct {
+	ctx   context.Context
+	fut   *Future
+	seq   uint64      // submission order, to keep equal priorities FIFO
+	index int         // position in the heap (-1 once out of it)
+	stop  func() bool // unregisters the dequeue on cancellation
+}
+
+// taskQueue is a container/heap of queued tasks, highest priority
+// first.
+type taskQueue []*queuedTask
+
+func (q taskQueue) Len() int { return len(q) }
+
+func (q taskQueue) Less(i, j int) bool {
+	if pi, pj := q[i].fut.Task.Priority, q[j].fut.Task.Priority; pi != pj {
+		return pi > pj
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q taskQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *taskQueue) Push(x any) {
+	item := x.(*queuedTask)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *taskQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*q = old[:n-1]
+	return item
+}
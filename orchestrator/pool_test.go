@@ -0,0 +1,276 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tparlmer/leopold/workspace"
+)
+
+func TestPoolRunsEverySubmittedTask(t *testing.T) {
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("happy"),
+		HeartbeatTimeout: 5 * time.Second,
+	}), 2)
+
+	var futures []*Future
+	for i := range 5 {
+		fut, err := pool.Submit(context.Background(), Task{ID: fmt.Sprintf("pool-%d", i), Repo: t.TempDir()})
+		if err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		futures = append(futures, fut)
+	}
+
+	pool.Wait()
+	for _, fut := range futures {
+		select {
+		case <-fut.Done():
+		default:
+			t.Fatalf("%s not done after Wait", fut.Task.ID)
+		}
+		if _, err := fut.Wait(); err != nil {
+			t.Errorf("%s: %v", fut.Task.ID, err)
+		}
+	}
+}
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("polite"), // runs until cancelled
+		HeartbeatTimeout: 5 * time.Second,
+	}), 2)
+
+	ctx0, cancel0 := context.WithCancel(context.Background())
+	defer cancel0()
+	first, _ := pool.Submit(ctx0, Task{ID: "pool-0", Repo: t.TempDir()})
+	pool.Submit(context.Background(), Task{ID: "pool-1", Repo: t.TempDir()})
+	pool.Submit(context.Background(), Task{ID: "pool-2", Repo: t.TempDir()})
+
+	waitStats(t, pool, 2, 1)
+
+	// Freeing a slot starts the queued task
+	cancel0()
+	if _, err := first.Wait(); !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+	waitStats(t, pool, 2, 0)
+
+	pool.Shutdown(canceledContext())
+}
+
+func TestPoolDropsTasksCancelledWhileQueued(t *testing.T) {
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("polite"),
+		HeartbeatTimeout: 5 * time.Second,
+	}), 1)
+	defer pool.Shutdown(canceledContext())
+
+	pool.Submit(context.Background(), Task{ID: "running", Repo: t.TempDir()})
+	ctx, cancel := context.WithCancel(context.Background())
+	queued, _ := pool.Submit(ctx, Task{ID: "queued", Repo: t.TempDir()})
+	waitStats(t, pool, 1, 1)
+
+	// The slot never frees up, but the task is resolved anyway
+	cancel()
+	select {
+	case <-queued.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled task still waiting for a slot")
+	}
+	if _, err := queued.Wait(); !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want ErrCancelled", err)
+	}
+	if r, q := pool.Stats(); r != 1 || q != 0 {
+		t.Errorf("pool has %d running, %d queued; want 1, 0", r, q)
+	}
+}
+
+func TestPoolRunsHigherPriorityFirst(t *testing.T) {
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("happy"), // takes a couple of hundred ms
+		HeartbeatTimeout: 5 * time.Second,
+	}), 1)
+
+	// The first task takes the only slot, so the rest queue up behind it
+	first, _ := pool.Submit(context.Background(), Task{ID: "first", Repo: t.TempDir()})
+	low, _ := pool.Submit(context.Background(), Task{ID: "low", Repo: t.TempDir()})
+	high, _ := pool.Submit(context.Background(), Task{ID: "high", Repo: t.TempDir(), Priority: 5})
+	mid, _ := pool.Submit(context.Background(), Task{ID: "mid", Repo: t.TempDir(), Priority: 1})
+	if r, q := pool.Stats(); r != 1 || q != 3 {
+		t.Fatalf("pool has %d running, %d queued; want 1, 3", r, q)
+	}
+	first.Wait()
+
+	// One at a time, so each finishes before the next even starts
+	for i, fut := range []*Future{high, mid, low} {
+		if _, err := fut.Wait(); err != nil {
+			t.Fatalf("%s: %v", fut.Task.ID, err)
+		}
+		for _, later := range []*Future{high, mid, low}[i+1:] {
+			select {
+			case <-later.Done():
+				t.Errorf("%s finished before %s", later.Task.ID, fut.Task.ID)
+			default:
+			}
+		}
+	}
+}
+
+func TestPoolShutdownDrainsThenCancels(t *testing.T) {
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("polite"),
+		HeartbeatTimeout: 5 * time.Second,
+	}), 1)
+
+	running, _ := pool.Submit(context.Background(), Task{ID: "running", Repo: t.TempDir()})
+	queued, _ := pool.Submit(context.Background(), Task{ID: "queued", Repo: t.TempDir()})
+	waitStats(t, pool, 1, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+
+	res, err := running.Wait()
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("running task err = %v, want ErrCancelled by ErrPoolClosed", err)
+	}
+	if res.Complete == nil || res.Complete.State != "cancelled" {
+		t.Errorf("running task wasn't cancelled gracefully: %+v", res.Complete)
+	}
+	if _, err := queued.Wait(); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("queued task err = %v, want ErrPoolClosed", err)
+	}
+	if _, err := pool.Submit(context.Background(), Task{ID: "late"}); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Submit after Shutdown = %v, want ErrPoolClosed", err)
+	}
+}
+
+// waitStats waits for the pool to reach the given running and queued
+// counts.
+func waitStats(t *testing.T, pool *Pool, running, queued int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r, q := pool.Stats()
+		if r == running && q == queued {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool has %d running, %d queued; want %d, %d", r, q, running, queued)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// canceledContext returns a context that is already done.
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// newGitRepo creates a git repository with one commit.
+func newGitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+	return repo
+}
+
+func TestPoolIsolatesTasksInWorkspaces(t *testing.T) {
+	repo := newGitRepo(t)
+
+	root := t.TempDir()
+	pool := NewPool(New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+		Workspace: workspace.New(workspace.Config{Root: root, OnSuccess: workspace.Merge}),
+	}), 3)
+
+	// Two tasks write to the repo at the same time, each in its own
+	// worktree; the third crashes
+	var futures []*Future
+	for _, task := range []Task{
+		{ID: "one", Prompt: "write one.txt", Repo: repo},
+		{ID: "two", Prompt: "write two.txt", Repo: repo},
+		{ID: "bad", Prompt: "crash", Repo: repo},
+	} {
+		fut, _ := pool.Submit(context.Background(), task)
+		futures = append(futures, fut)
+	}
+	pool.Wait()
+
+	for _, fut := range futures[:2] {
+		res, err := fut.Wait()
+		if err != nil {
+			t.Fatalf("%s: %v", fut.Task.ID, err)
+		}
+		if res.Workspace == nil || !res.Workspace.Merged {
+			t.Errorf("%s: workspace = %+v, want merged", fut.Task.ID, res.Workspace)
+		}
+		data, err := os.ReadFile(filepath.Join(repo, fut.Task.ID+".txt"))
+		if err != nil || string(data) != fut.Task.ID+"\n" {
+			t.Errorf("%s.txt = %q, %v; want it merged into the repo", fut.Task.ID, data, err)
+		}
+	}
+
+	res, err := futures[2].Wait()
+	if !errors.Is(err, ErrCrashed) {
+		t.Errorf("bad: err = %v, want ErrCrashed", err)
+	}
+	if res.Workspace == nil || res.Workspace.Kept || res.Workspace.Merged {
+		t.Errorf("bad: workspace = %+v, want it discarded", res.Workspace)
+	}
+
+	// Every worktree is gone, crashed or not
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("worktrees left behind: %v", entries)
+	}
+}
+
+func TestWorkspaceTaskRunsInRepoSubdirectory(t *testing.T) {
+	repo := newGitRepo(t)
+	sub := filepath.Join(repo, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orch := New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+		Workspace: workspace.New(workspace.Config{Root: t.TempDir(), OnSuccess: workspace.Merge}),
+		AllowedPaths: []string{"out.txt"}, // relative to Task.Repo, not the repo's top
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "sub", Prompt: "write out.txt", Repo: sub})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Files == nil || !slices.Equal(res.Files.Created, []string{"out.txt"}) {
+		t.Errorf("Files = %+v, want out.txt created", res.Files)
+	}
+	if data, err := os.ReadFile(filepath.Join(sub, "out.txt")); err != nil || string(data) != "sub\n" {
+		t.Errorf("sub/out.txt = %q, %v; want it merged into the subdirectory", data, err)
+	}
+}
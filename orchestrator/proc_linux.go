@@ -0,0 +1,160 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procInfo is the part of /proc/<pid>/stat the orchestrator cares about.
+type procInfo struct {
+	pid   int
+	state byte // 'R', 'S', ... 'Z' for zombies
+	ppid  int
+	pgrp  int
+}
+
+// listProcs returns every process currently visible in /proc.
+func listProcs() ([]procInfo, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]procInfo, 0, len(entries))
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue // not a process directory
+		}
+		info, err := readStat(pid)
+		if err != nil {
+			continue // exited while we were looking
+		}
+		procs = append(procs, info)
+	}
+	return procs, nil
+}
+
+// readStat parses /proc/<pid>/stat. The command name is in parentheses
+// and may itself contain spaces or parentheses, so fields are counted
+// from the last ')'.
+func readStat(pid int) (procInfo, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procInfo{}, err
+	}
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return procInfo{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	// After the name: state ppid pgrp ...
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 3 {
+		return procInfo{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procInfo{}, fmt.Errorf("malformed ppid for pid %d: %w", pid, err)
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return procInfo{}, fmt.Errorf("malformed pgrp for pid %d: %w", pid, err)
+	}
+	return procInfo{pid: pid, state: fields[0][0], ppid: ppid, pgrp: pgrp}, nil
+}
+
+// groupMembers returns the live (non-zombie) processes in process
+// group pgid.
+func groupMembers(pgid int) ([]int, error) {
+	procs, err := listProcs()
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, p := range procs {
+		if p.pgrp == pgid && p.state != 'Z' && p.state != 'X' {
+			pids = append(pids, p.pid)
+		}
+	}
+	return pids, nil
+}
+
+// descendants returns root and every process below it.
+func descendants(root int) ([]int, error) {
+	procs, err := listProcs()
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]int)
+	for _, p := range procs {
+		children[p.ppid] = append(children[p.ppid], p.pid)
+	}
+
+	tree := []int{root}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree, nil
+}
+
+// processTreeRSS measures the resident set size, in MB, of pid and all
+// its descendants. Processes that exit mid-measurement are skipped.
+func processTreeRSS(pid int) (float64, error) {
+	tree, err := descendants(pid)
+	if err != nil {
+		return 0, err
+	}
+	var totalKB int64
+	for _, p := range tree {
+		kb, err := rssKB(p)
+		if err != nil {
+			if p == pid {
+				return 0, err
+			}
+			continue
+		}
+		totalKB += kb
+	}
+	return float64(totalKB) / 1024, nil
+}
+
+// rssKB reads one process's RSS. smaps_rollup is the accurate source;
+// /proc/<pid>/status is the fallback on kernels that don't have it.
+func rssKB(pid int) (int64, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	if kb, err := readKBField(filepath.Join(dir, "smaps_rollup"), "Rss:"); err == nil {
+		return kb, nil
+	}
+	return readKBField(filepath.Join(dir, "status"), "VmRSS:")
+}
+
+// readKBField finds a "Name:   1234 kB" line in a /proc file.
+func readKBField(path, name string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, name) {
+			continue
+		}
+		fields := strings.Fields(line[len(name):])
+		if len(fields) == 0 {
+			break
+		}
+		return strconv.ParseInt(fields[0], 10, 64)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New(name + " not found in " + path)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package orchestrator
+
+import "errors"
+
+var errNoProcfs = errors.New("process tree inspection needs /proc")
+
+// processTreeRSS is only implemented on Linux. Elsewhere the
+// orchestrator falls back to the agent's self-reported RSS.
+func processTreeRSS(pid int) (float64, error) {
+	return 0, errNoProcfs
+}
+
+// groupMembers is only implemented on Linux, so leaked processes go
+// unreported elsewhere. They are still killed with the process group.
+func groupMembers(pgid int) ([]int, error) {
+	return nil, errNoProcfs
+}
@@ -0,0 +1,152 @@
+package orchestrator
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// agentProc tracks a spawned agent and remembers its exit status, so
+// every path out of the control loop can wait on it without caring
+// whether someone else already did.
+//
+// The agent leads its own process group, and signals go to the whole
+// group: killing the agent also kills the shells, test runners and
+// compilers it started.
+type agentProc struct {
+	cmd    *exec.Cmd
+	cg     *cgroup    // the agent's cgroup, if any, for finding leaks
+	waitCh chan error // receives cmd.Wait()'s result exactly once
+	exited bool
+	err    error
+
+	started  time.Time
+	exitedAt time.Time
+
+	signalled bool  // we've signalled the group, so its exit is our doing
+	leaked    []int // processes that outlived the agent's own exit
+}
+
+// startProc starts cmd in a new process group and launches the
+// goroutine that reaps it.
+func startProc(cmd *exec.Cmd) (*agentProc, error) {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &agentProc{cmd: cmd, waitCh: make(chan error, 1), started: time.Now()}
+	go func() {
+		err := cmd.Wait()
+		p.exitedAt = time.Now() // published to the reader by the send
+		p.waitCh <- err
+	}()
+	return p, nil
+}
+
+// pgid is the agent's process group ID, which is its PID.
+func (p *agentProc) pgid() int {
+	return p.cmd.Process.Pid
+}
+
+// observeExit records an exit status received directly from waitCh by
+// the control loop's select. If the agent exited on its own, anything
+// it started that is still alive has leaked.
+func (p *agentProc) observeExit(err error) {
+	p.exited, p.err = true, err
+	if !p.signalled {
+		p.leaked = p.survivors()
+	}
+}
+
+// elapsed is how long the agent has been running so far.
+func (p *agentProc) elapsed() time.Duration {
+	return time.Since(p.started)
+}
+
+// wallTime is how long the agent ran. Only valid once it has exited.
+func (p *agentProc) wallTime() time.Duration {
+	return p.exitedAt.Sub(p.started)
+}
+
+// exitStatus reports the agent's exit code and, if it was killed by a
+// signal, which one. Only valid once it has exited.
+func (p *agentProc) exitStatus() (int, os.Signal) {
+	ps := p.cmd.ProcessState
+	if ps == nil {
+		return -1, nil
+	}
+	return ps.ExitCode(), exitSignal(ps)
+}
+
+// survivors lists the live processes in the agent's process group and
+// cgroup, other than the agent itself.
+func (p *agentProc) survivors() []int {
+	pids, _ := groupMembers(p.pgid())
+	if p.cg != nil {
+		procs, _ := p.cg.procs()
+		for _, pid := range procs {
+			if !slices.Contains(pids, pid) {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	pids = slices.DeleteFunc(pids, func(pid int) bool { return pid == p.pgid() })
+	slices.Sort(pids)
+	return pids
+}
+
+// wait blocks until the agent has exited and returns its exit error.
+func (p *agentProc) wait() error {
+	if !p.exited {
+		p.observeExit(<-p.waitCh)
+	}
+	return p.err
+}
+
+// kill sends SIGKILL to the process group and waits for the agent to
+// exit. The group is signalled even if the agent already exited, to
+// take down anything it left behind.
+func (p *agentProc) kill() error {
+	p.signalled = true
+	signalGroup(p.pgid(), syscall.SIGKILL)
+	// Leaked processes that left the group are still in the cgroup
+	for _, pid := range p.leaked {
+		signalProcess(pid, syscall.SIGKILL)
+	}
+	return p.wait()
+}
+
+// terminate asks the process group to exit with SIGTERM and escalates
+// to SIGKILL if the agent is still around after grace.
+func (p *agentProc) terminate(grace time.Duration) error {
+	if p.exited {
+		return p.kill()
+	}
+	p.signalled = true
+	if err := signalGroup(p.pgid(), syscall.SIGTERM); err != nil {
+		return p.kill()
+	}
+
+	p.waitFor(grace)
+	return p.kill()
+}
+
+// waitFor waits up to grace for the agent to exit and reports whether
+// it did.
+func (p *agentProc) waitFor(grace time.Duration) bool {
+	if p.exited {
+		return true
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case err := <-p.waitCh:
+		p.observeExit(err)
+		return true
+	case <-timer.C:
+		return false
+	}
+}
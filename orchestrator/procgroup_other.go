@@ -0,0 +1,36 @@
+//go:build !unix
+
+package orchestrator
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is a no-op without Unix process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup can only reach the agent itself without Unix process
+// groups.
+func signalGroup(pgid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pgid)
+	if err != nil {
+		return err
+	}
+	if sig == syscall.SIGKILL {
+		return p.Kill()
+	}
+	return p.Signal(sig)
+}
+
+// signalProcess sends sig to a single process.
+func signalProcess(pid int, sig syscall.Signal) error {
+	return signalGroup(pid, sig)
+}
+
+// exitSignal can't tell how the process ended without Unix wait
+// statuses.
+func exitSignal(ps *os.ProcessState) os.Signal {
+	return nil
+}
@@ -0,0 +1,65 @@
+package orchestrator
+
+import (
+	"os"
+	"time"
+
+	"github.com/tparlmer/leopold/protocol"
+	"github.com/tparlmer/leopold/workspace"
+)
+
+// Task is one unit of work for an agent.
+//
+// Files, like Config.AllowedPaths, takes slash-separated paths relative
+// to Repo. Each covers the file it names and, for a directory,
+// everything under it. Globs are matched a path element at a time, so
+// "*" and "?" never cross a "/", and "**" matches any number of whole
+// elements. They're anchored at Repo: "*.md" covers README.md but not
+// docs/intro.md; "**/*.md" covers both.
+type Task struct {
+	ID     string // unique task identifier
+	Prompt string // what the agent should do
+	Repo   string // working directory
+
+	Priority  int      // order in a Pool's queue: higher runs first, FIFO among equals
+	DependsOn []string // IDs of tasks that must succeed first, when run as a graph
+	Context   string   // background passed to the agent along with the prompt
+	Files     []string // paths and globs the agent may change, within Config.AllowedPaths (nil = no narrower)
+}
+
+// TaskResult is everything the orchestrator observed about a task.
+//
+// RSS is tracked twice: what the orchestrator measured itself across the
+// agent's whole process tree, and what the agent claimed in its
+// heartbeats. A large gap between the two means the agent's
+// self-reporting can't be trusted.
+//
+// ExitCode and Signal describe how the agent process ended, whoever
+// ended it: an agent killed for a timeout shows SIGKILL here.
+type TaskResult struct {
+	Complete        *protocol.CompleteMessage  // the agent's final message (nil if it never sent one)
+	LastHeartbeat   *protocol.HeartbeatMessage // the most recent heartbeat (nil if none arrived)
+	ExitCode        int                        // the agent's exit status (-1 if killed by a signal or never started)
+	Signal          os.Signal                  // the signal that killed the agent (nil if it exited by itself)
+	WallTime        time.Duration              // from spawn until the agent exited
+	ExceededBudget  Budget                     // the token or time budget that stopped the task ("" if none)
+	CostUSD         float64                    // what the task cost, reported by the agent or priced from its tokens (summed over Attempts)
+	Attempts        []Attempt                  // every run of the task, oldest first (only with Config.Verify)
+	Workspace       *workspace.Outcome         // what became of the task's worktree (only with Config.Workspace)
+	Files           *FileReport                // what the agent really changed (only with Config.CheckFiles)
+	RolledBack      *workspace.Changes         // what was undone after the task failed (only with Config.Rollback)
+	ProtocolVersion int                        // protocol version spoken with the agent
+	PeakRSSMB       float64                    // highest RSS measured across the process tree
+	ReportedRSSMB   float64                    // highest RSS the agent reported in a heartbeat
+	Cgroup          string                     // the agent's cgroup ("" if it ran without one)
+	LeakedPIDs      []int                      // processes that outlived the agent; killed by the orchestrator
+}
+
+// Attempt is one run of a task under Config.Verify. The TaskResult
+// returned from Run describes the last attempt, with the full history
+// in Attempts.
+type Attempt struct {
+	Result       *TaskResult
+	Err          error         // what the run returned
+	Verification *Verification // nil if the agent didn't complete successfully
+}
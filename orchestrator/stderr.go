@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultStderrTailBytes is how much of the agent's stderr is kept for
+// error reports when Config.StderrTailBytes is zero.
+const DefaultStderrTailBytes = 8 << 10
+
+// stderrDrainTimeout bounds how long an error report waits for the
+// agent's last stderr output. Leaked children can hold the pipe open
+// indefinitely.
+const stderrDrainTimeout = 200 * time.Millisecond
+
+// StderrError wraps a crash or timeout with the last of what the agent
+// wrote to stderr - usually the panic or stack trace that explains it.
+type StderrError struct {
+	Err    error
+	Stderr string // tail of the agent's stderr, at most Config.StderrTailBytes
+}
+
+func (e *StderrError) Error() string {
+	return e.Err.Error() + "\nagent stderr:\n" + e.Stderr
+}
+
+func (e *StderrError) Unwrap() error {
+	return e.Err
+}
+
+// ringBuffer keeps the last size bytes written to it.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(p)
+	if n >= r.size {
+		r.buf = append(r.buf[:0], p[n-r.size:]...)
+		return n, nil
+	}
+	if over := len(r.buf) + n - r.size; over > 0 {
+		r.buf = append(r.buf[:0], r.buf[over:]...)
+	}
+	r.buf = append(r.buf, p...)
+	return n, nil
+}
+
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.buf)
+}
+
+// stderrCapture copies the agent's stderr into a ringBuffer, and
+// optionally a transcript, until the pipe closes.
+type stderrCapture struct {
+	ring *ringBuffer
+	done chan struct{}
+}
+
+func captureStderr(r io.Reader, ring *ringBuffer, log io.Writer) *stderrCapture {
+	c := &stderrCapture{ring: ring, done: make(chan struct{})}
+	var w io.Writer = ring
+	if log != nil {
+		w = io.MultiWriter(ring, log)
+	}
+	go func() {
+		defer close(c.done)
+		io.Copy(w, r)
+	}()
+	return c
+}
+
+// wrap attaches the stderr tail to err, once the agent's last output
+// has been copied (or stderrDrainTimeout passed). Without any stderr,
+// err is returned as it is.
+func (c *stderrCapture) wrap(err error) error {
+	select {
+	case <-c.done:
+	case <-time.After(stderrDrainTimeout):
+	}
+	tail := c.ring.String()
+	if tail == "" {
+		return err
+	}
+	return &StderrError{Err: err, Stderr: tail}
+}
@@ -0,0 +1,33 @@
+package orchestrator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRingBufferKeepsTail(t *testing.T) {
+	r := newRingBuffer(8)
+
+	r.Write([]byte("abc"))
+	r.Write([]byte("defg"))
+	if got := r.String(); got != "abcdefg" {
+		t.Errorf("got %q, want %q", got, "abcdefg")
+	}
+
+	r.Write([]byte("hij"))
+	if got := r.String(); got != "cdefghij" {
+		t.Errorf("got %q, want %q", got, "cdefghij")
+	}
+
+	r.Write([]byte(strings.Repeat("x", 20) + "12345678"))
+	if got := r.String(); got != "12345678" {
+		t.Errorf("got %q, want %q", got, "12345678")
+	}
+}
+
+func TestNegativeStderrTailBytesMeansDefault(t *testing.T) {
+	orch := New(Config{StderrTailBytes: -1})
+	if got := orch.config.StderrTailBytes; got != DefaultStderrTailBytes {
+		t.Errorf("StderrTailBytes = %d, want %d", got, DefaultStderrTailBytes)
+	}
+}
@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transcript is the on-disk record of one task run: everything sent to
+// the agent, everything it wrote back, and its stderr, one file each
+// under Config.LogDir/<task ID>. Every line is prefixed with the time
+// it passed through the orchestrator. Files are appended to, so retries
+// of a task end up in the same transcript.
+type transcript struct {
+	stdin  *lineLog
+	stdout *lineLog
+	stderr *lineLog
+}
+
+func openTranscript(logDir, taskID string) (*transcript, error) {
+	dir := filepath.Join(logDir, safeName(taskID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create log dir: %w", err)
+	}
+	t := &transcript{}
+	for _, f := range []struct {
+		name string
+		log  **lineLog
+	}{
+		{"stdin.log", &t.stdin},
+		{"stdout.log", &t.stdout},
+		{"stderr.log", &t.stderr},
+	} {
+		file, err := os.OpenFile(filepath.Join(dir, f.name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.close()
+			return nil, fmt.Errorf("open log: %w", err)
+		}
+		*f.log = &lineLog{w: file, c: file, bol: true}
+	}
+	return t, nil
+}
+
+func (t *transcript) close() {
+	for _, l := range []*lineLog{t.stdin, t.stdout, t.stderr} {
+		if l != nil {
+			l.close()
+		}
+	}
+}
+
+// lineLog writes a timestamp at the start of every line. It's safe for
+// concurrent use, and writes after close are dropped rather than
+// failing - a stray write from a reader that hasn't noticed the task is
+// over mustn't look like a broken agent.
+type lineLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	c      io.Closer
+	bol    bool // at the beginning of a line
+	closed bool
+}
+
+func (l *lineLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return len(p), nil
+	}
+	var b []byte
+	for _, c := range p {
+		if l.bol {
+			b = time.Now().AppendFormat(b, time.RFC3339Nano)
+			b = append(b, ' ')
+			l.bol = false
+		}
+		b = append(b, c)
+		l.bol = c == '\n'
+	}
+	if _, err := l.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *lineLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed && !l.bol {
+		// Leave the file ending in a newline for the next run
+		l.w.Write([]byte("\n"))
+	}
+	l.closed = true
+	l.c.Close()
+}
+
+// safeName makes a task ID safe to use as a file or directory name.
+func safeName(taskID string) string {
+	if strings.Trim(taskID, ".") == "" {
+		// "", "." and ".." would all name some other directory
+		return strings.Repeat("_", max(len(taskID), 1))
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, taskID)
+}
@@ -0,0 +1,173 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// VerifyConfig checks an agent's work instead of taking its word for
+// it. After an agent completes with state "done", Command runs in the
+// task's repo; if it fails, a fresh agent gets the same task, with the
+// command's output added to its Context, until the command passes or
+// MaxAttempts agents have tried.
+//
+// Only verification failures are retried. An agent that crashes, times
+// out or completes with any other state ends the task as it would
+// without verification.
+//
+// Budgets apply to each attempt on its own: every agent gets the full
+// MaxDuration, token and cost budgets, so a task can spend up to
+// MaxAttempts times as much in all. The result's CostUSD is the total.
+type VerifyConfig struct {
+	Command        []string      // e.g. {"go", "test", "./..."}
+	Timeout        time.Duration // per run of Command, which fails if exceeded (0 = no limit)
+	MaxAttempts    int           // agents to try, including the first (0 = DefaultMaxAttempts)
+	MaxOutputBytes int           // tail of Command's output kept and passed on (0 = DefaultVerifyOutputBytes)
+}
+
+// Defaults for VerifyConfig.
+const (
+	DefaultMaxAttempts       = 3
+	DefaultVerifyOutputBytes = 16 << 10
+)
+
+// Verification is the outcome of one run of the verification command.
+type Verification struct {
+	Passed   bool
+	ExitCode int    // -1 if it was killed or couldn't start
+	Output   string // combined stdout and stderr, at most MaxOutputBytes from the end
+	TimedOut bool
+	Duration time.Duration
+}
+
+// runVerified runs task until its verification passes or it runs out
+// of attempts.
+func (o *Orchestrator) runVerified(ctx context.Context, task Task) (*TaskResult, error) {
+	cfg := o.config.Verify
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	var attempts []Attempt
+	// final is the last attempt's result with the history attached and
+	// what they all cost. A copy, so the history doesn't contain itself.
+	final := func(res *TaskResult) *TaskResult {
+		r := *res
+		r.Attempts = attempts
+		r.CostUSD = 0
+		for _, a := range attempts {
+			r.CostUSD += a.Result.CostUSD
+		}
+		return &r
+	}
+
+	attempt := task
+	for n := 1; ; n++ {
+		res, err := o.runOnce(ctx, attempt)
+		attempts = append(attempts, Attempt{Result: res, Err: err})
+		if err != nil || res.Complete == nil || res.Complete.State != "done" {
+			return final(res), err
+		}
+
+		v, err := o.verify(ctx, task.Repo)
+		if err != nil {
+			return final(res), err
+		}
+		attempts[len(attempts)-1].Verification = v
+		if v.Passed {
+			return final(res), nil
+		}
+		o.config.Logger.Warn("verification failed", "task_id", task.ID, "attempt", n,
+			"exit_code", v.ExitCode, "timed_out", v.TimedOut)
+		if n >= maxAttempts {
+			return final(res), fmt.Errorf("%w after %d attempts: %s",
+				ErrVerifyFailed, n, v.describe(cfg.Command))
+		}
+
+		attempt.Context = retryContext(task.Context, n, cfg.Command, v)
+	}
+}
+
+// verify runs the verification command in repo. The error is only for
+// a command that couldn't be run at all, or a cancelled ctx; a command
+// that ran and failed is a Verification that didn't pass.
+func (o *Orchestrator) verify(ctx context.Context, repo string) (*Verification, error) {
+	cfg := o.config.Verify
+	if len(cfg.Command) == 0 {
+		return nil, fmt.Errorf("verify: empty command")
+	}
+	outputBytes := cfg.MaxOutputBytes
+	if outputBytes <= 0 {
+		outputBytes = DefaultVerifyOutputBytes
+	}
+
+	runCtx := ctx
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	out := newRingBuffer(outputBytes)
+	cmd := exec.CommandContext(runCtx, cfg.Command[0], cfg.Command[1:]...)
+	cmd.Dir = repo
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Test runners fork; take the whole group down on timeout, and
+	// don't wait forever on output pipes a straggler holds open.
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return signalGroup(cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	v := &Verification{
+		Passed:   err == nil,
+		ExitCode: -1,
+		Output:   out.String(),
+		Duration: time.Since(start),
+	}
+	if cmd.ProcessState != nil {
+		v.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return nil, cancelledError(context.Cause(ctx))
+	case runCtx.Err() != nil:
+		v.Passed, v.TimedOut = false, true
+	case err != nil && !errors.As(err, &exitErr):
+		return nil, fmt.Errorf("run verify command: %w", err)
+	}
+	return v, nil
+}
+
+// describe summarises a failed verification in one line.
+func (v *Verification) describe(command []string) string {
+	if v.TimedOut {
+		return fmt.Sprintf("`%s` timed out after %s", strings.Join(command, " "), v.Duration.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("`%s` exited with status %d", strings.Join(command, " "), v.ExitCode)
+}
+
+// retryContext is the Context for the attempt after a failed one: the
+// task's own context, then what went wrong.
+func retryContext(base string, attempt int, command []string, v *Verification) string {
+	var b strings.Builder
+	if base != "" {
+		b.WriteString(base)
+		b.WriteString("\n\n")
+	}
+	fmt.Fprintf(&b, "Attempt %d at this task completed, but verification failed: %s. Output:\n%s",
+		attempt, v.describe(command), v.Output)
+	return b.String()
+}
@@ -0,0 +1,116 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyCheck fails its first n-1 runs in a repo and passes after that.
+func flakyCheck(n int) []string {
+	return []string{"sh", "-c", fmt.Sprintf(`
+		runs=$(( $(cat .runs 2>/dev/null || echo 0) + 1 ))
+		echo $runs > .runs
+		if [ $runs -lt %d ]; then
+			echo "--- FAIL: TestLogin (run $runs)"
+			exit 1
+		fi
+		echo ok`, n)}
+}
+
+func TestVerifyRetriesWithFailureOutput(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("echo"), // puts its context in the summary
+		HeartbeatTimeout: 5 * time.Second,
+		Pricing: map[string]Price{"": {InputUSD: 0.25, OutputUSD: 0.25}},
+		Verify: &VerifyConfig{Command: flakyCheck(2)},
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "verify-1", Prompt: "fix login", Repo: t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Attempts) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(res.Attempts))
+	}
+
+	first := res.Attempts[0].Verification
+	if first == nil || first.Passed || first.ExitCode != 1 || !strings.Contains(first.Output, "FAIL: TestLogin (run 1)") {
+		t.Errorf("first verification = %+v, want a failure with the test output", first)
+	}
+	if v := res.Attempts[1].Verification; v == nil || !v.Passed {
+		t.Errorf("second verification = %+v, want a pass", v)
+	}
+
+	// The second agent was told why the first attempt didn't count
+	summary := res.Complete.Summary
+	if !strings.Contains(summary, "verification failed") || !strings.Contains(summary, "FAIL: TestLogin (run 1)") {
+		t.Errorf("retry wasn't given the failure output: %q", summary)
+	}
+	if res.Complete != res.Attempts[1].Result.Complete {
+		t.Errorf("result doesn't describe the last attempt")
+	}
+	// Each agent used a token either way, at $0.25 apiece
+	if res.CostUSD != 1 {
+		t.Errorf("CostUSD = %v, want 1 for both attempts", res.CostUSD)
+	}
+}
+
+func TestVerifyGivesUpAfterMaxAttempts(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+		Verify: &VerifyConfig{Command: flakyCheck(9), MaxAttempts: 2},
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "verify-2", Prompt: "fix login", Repo: t.TempDir()})
+	if !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("err = %v, want ErrVerifyFailed", err)
+	}
+	if len(res.Attempts) != 2 {
+		t.Errorf("got %d attempts, want 2", len(res.Attempts))
+	}
+}
+
+func TestVerifyDoesNotRetryAgentFailures(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+		Verify: &VerifyConfig{Command: []string{"true"}},
+	})
+
+	res, err := orch.Run(context.Background(), Task{ID: "verify-3", Prompt: "crash", Repo: t.TempDir()})
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("err = %v, want ErrCrashed", err)
+	}
+	if len(res.Attempts) != 1 || res.Attempts[0].Verification != nil {
+		t.Errorf("attempts = %+v, want one, unverified", res.Attempts)
+	}
+}
+
+func TestVerifyTimeoutCountsAsFailure(t *testing.T) {
+	orch := New(Config{
+		AgentBin: agentBin("echo"),
+		HeartbeatTimeout: 5 * time.Second,
+		Verify: &VerifyConfig{
+			Command: []string{"sh", "-c", "echo starting; sleep 30"},
+			Timeout: 200 * time.Millisecond,
+			MaxAttempts: 1,
+		},
+	})
+
+	start := time.Now()
+	res, err := orch.Run(context.Background(), Task{ID: "verify-4", Prompt: "fix login", Repo: t.TempDir()})
+	if !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("err = %v, want ErrVerifyFailed", err)
+	}
+	if v := res.Attempts[0].Verification; !v.TimedOut || !strings.Contains(v.Output, "starting") {
+		t.Errorf("verification = %+v, want a timeout with partial output", v)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %s, want the verification cut short", elapsed)
+	}
+}
@@ -0,0 +1,9 @@
+package orchestrator
+
+// fsEvent is a change to a file in a watched tree. Path is
+// slash-separated and relative to the tree's root, like the paths in
+// workspace.Changes.
+type fsEvent struct {
+	path string
+	op   FileOp
+}
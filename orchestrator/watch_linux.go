@@ -0,0 +1,198 @@
+//go:build linux
+
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// watchMask is what the watcher asks inotify for on every directory.
+const watchMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW | syscall.IN_EXCL_UNLINK
+
+// watcher reports changes under a directory tree as they happen, via
+// inotify. Directories created inside the tree are watched as they
+// appear, and the files already in them reported, since they may have
+// been written before the watch was in place. .git directories are
+// skipped.
+//
+// If the kernel's queue overflows, events are lost; the watcher is a
+// fast path, not a replacement for comparing snapshots.
+type watcher struct {
+	events chan fsEvent // closed when the watcher stops
+
+	root string
+	f    *os.File
+	wds  map[int32]string // watch descriptor -> directory; reader goroutine only
+	done chan struct{}
+	once sync.Once
+}
+
+// watchTree starts watching root and everything under it.
+func watchTree(root string) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &watcher{
+		events: make(chan fsEvent, 64),
+		root:   filepath.Clean(root),
+		// Non-blocking, so reads go through the runtime poller and
+		// stop() can interrupt them
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		wds:  make(map[int32]string),
+		done: make(chan struct{}),
+	}
+	if err := w.addTree(w.root, false); err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	go w.read()
+	return w, nil
+}
+
+// stop stops the watcher and releases its file descriptor.
+func (w *watcher) stop() {
+	w.once.Do(func() {
+		close(w.done)
+		w.f.Close()
+	})
+}
+
+func (w *watcher) read() {
+	defer close(w.events)
+	const header = syscall.SizeofInotifyEvent
+	buf := make([]byte, 64*(header+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			// Stopped, most likely. Either way, nothing more to read.
+			return
+		}
+		for off := 0; off+header <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[off:]))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			size := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := string(bytes.TrimRight(buf[off+header:off+header+size], "\x00"))
+			off += header + size
+			if !w.handle(wd, mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// handle turns one inotify event into fsEvents. Returns false once the
+// watcher has been stopped.
+func (w *watcher) handle(wd int32, mask uint32, name string) bool {
+	if mask&syscall.IN_IGNORED != 0 {
+		// The directory went away, taking its watch with it
+		delete(w.wds, wd)
+		return true
+	}
+	dir, ok := w.wds[wd]
+	if !ok {
+		return true
+	}
+	path := filepath.Join(dir, name)
+
+	if mask&syscall.IN_ISDIR != 0 {
+		// Files in a removed directory get their own events; a new one
+		// needs watching.
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && name != ".git" {
+			if err := w.addTree(path, true); errors.Is(err, errWatcherStopped) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var op FileOp
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		op = FileCreated
+	case mask&syscall.IN_MODIFY != 0:
+		op = FileWritten
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		op = FileRemoved
+	default:
+		return true
+	}
+	return w.emit(path, op)
+}
+
+// addTree watches dir and the directories under it. With report set,
+// the files found are reported as created.
+func (w *watcher) addTree(dir string, report bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != dir && errors.Is(err, fs.ErrNotExist) {
+				// Gone already; its removal has been reported
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			if report && !w.emit(path, FileCreated) {
+				return errWatcherStopped
+			}
+			return nil
+		}
+		if d.Name() == ".git" && path != w.root {
+			return filepath.SkipDir
+		}
+		wd, err := w.addWatch(path)
+		if err != nil {
+			if path != dir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		w.wds[wd] = path
+		return nil
+	})
+}
+
+func (w *watcher) addWatch(dir string) (int32, error) {
+	conn, err := w.f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var wd int
+	var addErr error
+	err = conn.Control(func(fd uintptr) {
+		wd, addErr = syscall.InotifyAddWatch(int(fd), dir, watchMask)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if addErr != nil {
+		return 0, os.NewSyscallError("inotify_add_watch", addErr)
+	}
+	return int32(wd), nil
+}
+
+// emit sends an event for path. Returns false once the watcher has
+// been stopped.
+func (w *watcher) emit(path string, op FileOp) bool {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil {
+		return true
+	}
+	select {
+	case w.events <- fsEvent{path: filepath.ToSlash(rel), op: op}:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+var errWatcherStopped = errors.New("watcher stopped")
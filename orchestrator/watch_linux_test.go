@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWatcherFollowsNewDirectories(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, ".git"), 0o755)
+	w, err := watchTree(dir)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer w.stop()
+
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".git", "index"), []byte("ignored"), 0o644)
+	os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0o755)
+	os.WriteFile(filepath.Join(dir, "sub", "deep", "b.txt"), []byte("b"), 0o644)
+	os.Remove(filepath.Join(dir, "a.txt"))
+
+	want := map[fsEvent]bool{
+		{"a.txt", FileCreated}:          true,
+		{"a.txt", FileRemoved}:          true,
+		{"sub/deep/b.txt", FileCreated}: true,
+	}
+	var seen []fsEvent
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case ev := <-w.events:
+			seen = append(seen, ev)
+			delete(want, ev)
+		case <-timeout:
+			t.Fatalf("missing %v; saw %v", want, seen)
+		}
+	}
+	if slices.ContainsFunc(seen, func(ev fsEvent) bool { return ev.path == ".git/index" }) {
+		t.Errorf("events inside .git: %v", seen)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package orchestrator
+
+import "errors"
+
+// watcher is a stub: live watching needs inotify, so elsewhere only
+// the snapshot comparison after the task catches stray writes.
+type watcher struct {
+	events chan fsEvent
+}
+
+func watchTree(root string) (*watcher, error) {
+	return nil, errors.New("watching files needs inotify")
+}
+
+func (w *watcher) stop() {}
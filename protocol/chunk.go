@@ -0,0 +1,57 @@
+package protocol
+
+import "fmt"
+
+// chunkBuffer collects the pieces of one chunked message.
+type chunkBuffer struct {
+	next int // Seq expected next
+	data []byte
+}
+
+// addChunk adds c to its message. Once the last chunk is in, it
+// returns the whole encoded message and done. Any error discards what
+// was collected under c.ID so far.
+//
+// The size limit covers every partly received message together, so an
+// agent can't get around it by interleaving lots of IDs.
+func (d *Decoder) addChunk(c *ChunkMessage) (data []byte, done bool, err error) {
+	if d.chunks == nil {
+		d.chunks = make(map[string]*chunkBuffer)
+	}
+	buf := d.chunks[c.ID]
+	if buf == nil {
+		buf = &chunkBuffer{}
+		d.chunks[c.ID] = buf
+	}
+
+	if c.Seq != buf.next {
+		d.dropChunks(c.ID)
+		return nil, false, fmt.Errorf("chunk %q: got seq %d, want %d", c.ID, c.Seq, buf.next)
+	}
+	if d.chunked+len(c.Data) > d.maxChunked {
+		d.dropChunks(c.ID)
+		if len(d.chunks) > 0 {
+			return nil, false, fmt.Errorf("%w: chunked messages pending with %q exceed %d bytes",
+				ErrMessageTooLarge, c.ID, d.maxChunked)
+		}
+		return nil, false, fmt.Errorf("%w: chunked message %q exceeds %d bytes",
+			ErrMessageTooLarge, c.ID, d.maxChunked)
+	}
+	buf.data = append(buf.data, c.Data...)
+	buf.next++
+	d.chunked += len(c.Data)
+
+	if c.More {
+		return nil, false, nil
+	}
+	d.dropChunks(c.ID)
+	return buf.data, true, nil
+}
+
+// dropChunks forgets the message collected under id.
+func (d *Decoder) dropChunks(id string) {
+	if buf := d.chunks[id]; buf != nil {
+		d.chunked -= len(buf.data)
+		delete(d.chunks, id)
+	}
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChunkedMessageRoundTrip(t *testing.T) {
+	// Multi-byte characters so some chunk boundaries fall mid-rune
+	diff := strings.Repeat("+ héllo wörld ✓\n", 500)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeChunked("c1", CompleteMessage{ID: "t1", State: "done", Summary: diff}, 1000); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines < 2 {
+		t.Fatalf("got %d lines, want several chunks", lines)
+	}
+
+	// Each line fits the limit; the reassembled message doesn't
+	dec := NewDecoder(&buf)
+	dec.SetMaxMessageBytes(2000)
+
+	msg, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	complete, ok := msg.(*CompleteMessage)
+	if !ok {
+		t.Fatalf("got %T, want *CompleteMessage", msg)
+	}
+	if complete.Summary != diff {
+		t.Errorf("summary mangled: got %d bytes, want %d", len(complete.Summary), len(diff))
+	}
+}
+
+func TestChunksOutOfOrderAreRejected(t *testing.T) {
+	input := `{"type":"chunk","v":1,"id":"c1","seq":0,"data":"{\"type\":","more":true}
+{"type":"chunk","v":1,"id":"c1","seq":2,"data":"\"complete\"}"}
+{"type":"complete","v":1,"id":"t1","state":"done"}
+`
+	dec := NewDecoder(strings.NewReader(input))
+
+	_, err := dec.Decode()
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("err = %v, want *ParseError", err)
+	}
+
+	// The stream carries on after the bad chunk
+	if _, err := dec.Decode(); err != nil {
+		t.Fatalf("decode after bad chunk: %v", err)
+	}
+}
+
+func TestChunkedMessageLimit(t *testing.T) {
+	var buf bytes.Buffer
+	summary := strings.Repeat("x", 5000)
+	if err := NewEncoder(&buf).EncodeChunked("c1", CompleteMessage{ID: "t1", Summary: summary}, 500); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	dec := NewDecoder(&buf)
+	dec.SetMaxChunkedBytes(2000)
+
+	_, err := dec.Decode()
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("err = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestChunkedMessageLimitCoversAllIDs(t *testing.T) {
+	// Three messages of 800 bytes each are fine one at a time, but not
+	// interleaved under a 2000 byte limit.
+	var buf bytes.Buffer
+	for i := range 3 {
+		fmt.Fprintf(&buf, `{"type":"chunk","v":1,"id":"c%d","seq":0,"data":%q,"more":true}`+"\n", i, strings.Repeat("x", 800))
+	}
+	dec := NewDecoder(&buf)
+	dec.SetMaxChunkedBytes(2000)
+
+	_, err := dec.Decode()
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("err = %v, want ErrMessageTooLarge", err)
+	}
+}
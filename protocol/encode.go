@@ -0,0 +1,252 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Encoder writes messages as JSON lines. It fills in each message's
+// Type and Version, so callers only set the payload fields and can't
+// send a message with a wrong or empty type.
+type Encoder struct {
+	w       io.Writer
+	version int
+}
+
+// NewEncoder returns an Encoder that writes to w, stamping messages
+// with ProtocolVersion.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, version: ProtocolVersion}
+}
+
+// SetVersion changes the version stamped on subsequent messages, once
+// one has been negotiated.
+func (e *Encoder) SetVersion(v int) {
+	e.version = v
+}
+
+// Encode writes msg as a single JSON line.
+func (e *Encoder) Encode(msg Message) error {
+	data, err := json.Marshal(msg.stamp(e.version))
+	if err != nil {
+		return fmt.Errorf("marshal %s message: %w", msg.MessageType(), err)
+	}
+	// One JSON object per line - the newline is the message boundary
+	data = append(data, '\n')
+	_, err = e.w.Write(data)
+	return err
+}
+
+// EncodeChunked writes msg as a run of ChunkMessages under id, each
+// carrying at most size bytes of the encoded message. Use it for
+// messages that may exceed the reader's line limit.
+func (e *Encoder) EncodeChunked(id string, msg Message, size int) error {
+	if size < utf8.UTFMax {
+		return fmt.Errorf("chunk size %d too small", size)
+	}
+	data, err := json.Marshal(msg.stamp(e.version))
+	if err != nil {
+		return fmt.Errorf("marshal %s message: %w", msg.MessageType(), err)
+	}
+	for seq := 0; ; seq++ {
+		n := min(size, len(data))
+		// Never split a character - Data is a JSON string, and half a
+		// rune in one would come back as U+FFFD
+		for n < len(data) && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		chunk := ChunkMessage{ID: id, Seq: seq, Data: string(data[:n]), More: n < len(data)}
+		if err := e.Encode(chunk); err != nil {
+			return err
+		}
+		data = data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// ParseError is returned by Decoder.Decode for a line that isn't a
+// valid message. The stream itself is still usable.
+type ParseError struct {
+	Line []byte // the offending line (only the start, if it was oversized)
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return "parse: " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultMaxMessageBytes is the longest line a Decoder accepts unless
+// told otherwise. Anything bigger has to be sent as chunks.
+const DefaultMaxMessageBytes = 1 << 20
+
+// DefaultMaxChunkedBytes bounds the messages being reassembled from
+// chunks, all together.
+const DefaultMaxChunkedBytes = 64 << 20
+
+// ErrMessageTooLarge is wrapped by the *ParseError for a line longer
+// than the Decoder's limit, or a chunked message that outgrew its own.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// parseErrorLineMax is how much of an oversized line a ParseError keeps.
+const parseErrorLineMax = 256
+
+// Decoder reads JSON-line messages from a stream.
+//
+// Until SetVersion is called, any version this package supports is
+// accepted. After it, messages are upgraded to that version or rejected.
+// Either way, a message in a version we can't handle is a *ParseError
+// wrapping ErrIncompatibleVersion.
+//
+// Lines are read incrementally and an oversized one is skipped, not
+// buffered, so a misbehaving agent can't make the Decoder allocate
+// without bound. ChunkMessages are reassembled transparently.
+type Decoder struct {
+	r          *bufio.Reader
+	line       []byte
+	version    int // negotiated version (0 = any supported version)
+	maxMessage int
+	maxChunked int
+	chunks     map[string]*chunkBuffer
+	chunked    int // bytes held in chunks
+}
+
+// NewDecoder returns a Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:          bufio.NewReader(r),
+		maxMessage: DefaultMaxMessageBytes,
+		maxChunked: DefaultMaxChunkedBytes,
+	}
+}
+
+// SetVersion fixes the version of the conversation. It must not be
+// called concurrently with Decode.
+func (d *Decoder) SetVersion(v int) {
+	d.version = v
+}
+
+// SetMaxMessageBytes sets the longest line Decode accepts, not counting
+// the newline. Non-positive n restores DefaultMaxMessageBytes.
+func (d *Decoder) SetMaxMessageBytes(n int) {
+	if n <= 0 {
+		n = DefaultMaxMessageBytes
+	}
+	d.maxMessage = n
+}
+
+// SetMaxChunkedBytes sets the largest message Decode will reassemble
+// from chunks. The limit also covers all the messages still coming in
+// at once, however many IDs they're spread across. Non-positive n
+// restores DefaultMaxChunkedBytes.
+func (d *Decoder) SetMaxChunkedBytes(n int) {
+	if n <= 0 {
+		n = DefaultMaxChunkedBytes
+	}
+	d.maxChunked = n
+}
+
+// Decode reads the next message. It returns a *ParseError for a
+// malformed or oversized line, after which decoding can continue,
+// io.EOF when the stream ends cleanly, and any other error if reading
+// failed.
+func (d *Decoder) Decode() (Message, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+		msg, err := d.parse(line)
+		if err != nil {
+			return nil, &ParseError{Line: append([]byte(nil), line...), Err: err}
+		}
+		chunk, ok := msg.(*ChunkMessage)
+		if !ok {
+			return msg, nil
+		}
+		data, done, err := d.addChunk(chunk)
+		if err != nil {
+			return nil, &ParseError{Line: append([]byte(nil), line...), Err: err}
+		}
+		if !done {
+			continue
+		}
+		msg, err = d.parse(data)
+		if err == nil {
+			if _, ok := msg.(*ChunkMessage); ok {
+				err = fmt.Errorf("chunk %q contains another chunk", chunk.ID)
+			}
+		}
+		if err != nil {
+			return nil, &ParseError{Line: truncate(data), Err: err}
+		}
+		return msg, nil
+	}
+}
+
+// parse turns one line into a message in a version we can handle.
+func (d *Decoder) parse(line []byte) (Message, error) {
+	msg, err := ParseMessage(line)
+	if err != nil {
+		return nil, err
+	}
+	if d.version == 0 {
+		return msg, checkSupported(msg)
+	}
+	return Upgrade(msg, d.version)
+}
+
+// readLine returns the next line without its line ending. The slice is
+// only valid until the next call. An oversized line is consumed up to
+// its newline and reported as a *ParseError.
+func (d *Decoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	n := 0
+	for {
+		frag, err := d.r.ReadSlice('\n')
+		n += len(frag)
+		if n <= d.maxMessage+2 { // room for "\r\n"
+			d.line = append(d.line, frag...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && n > 0 {
+			// Last line without a newline - still a line
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	line := bytes.TrimSuffix(d.line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if n > d.maxMessage+2 || len(line) > d.maxMessage {
+		return nil, &ParseError{
+			Line: truncate(line),
+			Err:  fmt.Errorf("%w: line of %d bytes, limit %d", ErrMessageTooLarge, n, d.maxMessage),
+		}
+	}
+	return line, nil
+}
+
+// truncate copies at most parseErrorLineMax bytes of line, for a
+// ParseError.
+func truncate(line []byte) []byte {
+	if len(line) > parseErrorLineMax {
+		line = line[:parseErrorLineMax]
+	}
+	return append([]byte(nil), line...)
+}
@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Every message struct must satisfy the sealed Message interface.
+var (
+	_ Message = InitMessage{}
+	_ Message = TaskMessage{}
+	_ Message = CancelMessage{}
+	_ Message = AnswerMessage{}
+	_ Message = HeartbeatMessage{}
+	_ Message = BlockedMessage{}
+	_ Message = CompleteMessage{}
+	_ Message = HelloMessage{}
+	_ Message = ChunkMessage{}
+)
+
+func TestEncoderFillsTypeAndVersion(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+
+	// Type deliberately wrong and Version left out
+	if err := enc.Encode(InitMessage{Type: "task", HeartbeatIntervalS: 15}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if err := enc.Encode(&CancelMessage{ID: "t1", Reason: "shutdown"}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+
+	for i, want := range []string{TypeInit, TypeCancel} {
+		msg, err := ParseMessage([]byte(lines[i]))
+		if err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if msg.MessageType() != want {
+			t.Errorf("line %d type = %q, want %q", i, msg.MessageType(), want)
+		}
+		if msg.ProtocolVersion() != ProtocolVersion {
+			t.Errorf("line %d version = %d, want %d", i, msg.ProtocolVersion(), ProtocolVersion)
+		}
+	}
+}
+
+func TestEncoderDoesNotModifyCaller(t *testing.T) {
+	msg := &AnswerMessage{ID: "t1", Response: "yes"}
+	if err := NewEncoder(io.Discard).Encode(msg); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if msg.Type != "" || msg.Version != 0 {
+		t.Errorf("caller's message modified: %+v", msg)
+	}
+}
+
+func TestDecoderReadsMessagesAndSkipsGarbage(t *testing.T) {
+	input := `{"type":"heartbeat","v":1,"id":"t1","state":"running","tool":"bash","detail":"ok","rss_mb":1,"tokens_in":1,"tokens_out":1,"elapsed_s":1}
+this is not json
+{"type":"complete","v":1,"id":"t1","state":"done","tokens_in":1,"tokens_out":1,"elapsed_s":1}
+`
+	dec := NewDecoder(strings.NewReader(input))
+
+	msg, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("first decode: %v", err)
+	}
+	if _, ok := msg.(*HeartbeatMessage); !ok {
+		t.Errorf("first message = %T, want *HeartbeatMessage", msg)
+	}
+
+	_, err = dec.Decode()
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("second decode err = %v, want *ParseError", err)
+	}
+	if string(perr.Line) != "this is not json" {
+		t.Errorf("ParseError.Line = %q", perr.Line)
+	}
+
+	msg, err = dec.Decode()
+	if err != nil {
+		t.Fatalf("third decode: %v", err)
+	}
+	if _, ok := msg.(*CompleteMessage); !ok {
+		t.Errorf("third message = %T, want *CompleteMessage", msg)
+	}
+
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("final decode err = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderAcceptsLinesPastScannerLimit(t *testing.T) {
+	// bufio.Scanner gave up at 64 KiB; the default limit is far above that
+	var buf bytes.Buffer
+	summary := strings.Repeat("x", 200<<10)
+	if err := NewEncoder(&buf).Encode(CompleteMessage{ID: "t1", State: "done", Summary: summary}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	msg, err := NewDecoder(&buf).Decode()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got := msg.(*CompleteMessage).Summary; got != summary {
+		t.Errorf("summary has %d bytes, want %d", len(got), len(summary))
+	}
+}
+
+func TestDecoderRejectsOversizedLineAndContinues(t *testing.T) {
+	input := `{"type":"complete","v":1,"id":"t1","state":"done","summary":"` + strings.Repeat("x", 10000) + `"}
+{"type":"complete","v":1,"id":"t1","state":"done","summary":"short"}
+`
+	dec := NewDecoder(strings.NewReader(input))
+	dec.SetMaxMessageBytes(1024)
+
+	_, err := dec.Decode()
+	var perr *ParseError
+	if !errors.As(err, &perr) || !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("err = %v, want *ParseError wrapping ErrMessageTooLarge", err)
+	}
+	if len(perr.Line) > parseErrorLineMax {
+		t.Errorf("ParseError kept %d bytes of the line", len(perr.Line))
+	}
+
+	msg, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("decode after oversized line: %v", err)
+	}
+	if got := msg.(*CompleteMessage).Summary; got != "short" {
+		t.Errorf("summary = %q, want %q", got, "short")
+	}
+}
+
+func TestDecoderReadsFinalLineWithoutNewline(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`{"type":"complete","v":1,"id":"t1","state":"done"}` + "\r"))
+
+	if _, err := dec.Decode(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+}
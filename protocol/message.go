@@ -5,6 +5,33 @@ package protocol
 // change in a breaking way.
 const ProtocolVersion = 1
 
+// Message type strings, as carried in every message's "type" field.
+const (
+	TypeInit = "init"
+	TypeTask = "task"
+	TypeCancel = "cancel"
+	TypeAnswer = "answer"
+	TypeHeartbeat = "heartbeat"
+	TypeBlocked = "blocked"
+	TypeComplete = "complete"
+	TypeHello = "hello"
+	TypeChunk = "chunk"
+)
+
+// Message is implemented by every protocol message struct, and only by
+// them - the unexported method seals the interface, so a type switch
+// over the structs below is exhaustive.
+//
+// MessageType is the type string the message should carry, whatever
+// its Type field currently says. ProtocolVersion is the version the
+// message claims.
+type Message interface {
+	MessageType() string
+	ProtocolVersion() int
+
+	// stamp returns a copy with Type and Version filled in.
+	stamp(version int) Message
+}
 
 // --- Orchestrator -> Agent messages ---
 
@@ -19,14 +46,21 @@ type InitMessage struct {
 	Type string `json:"type"` // always "init"
 	Version int `json:"v"` // protocol version
 	HeartbeatIntervalS int `json:"heartbeat_interval_s"` // how often agent should send status/metric
-	MaxTokens int `json:"max_tokens,omitempty"` // token budget (0 = unlimited)
-	// TODO: max_duration_s - optional wall-clock budget per task
+	MaxTokens int `json:"max_tokens,omitempty"` // combined input+output token budget (0 = unlimited)
+	MaxTokensIn int `json:"max_tokens_in,omitempty"` // input token budget (0 = unlimited)
+	MaxTokensOut int `json:"max_tokens_out,omitempty"` // output token budget (0 = unlimited)
+	MaxDurationS float64 `json:"max_duration_s,omitempty"` // wall-clock budget for the task (0 = unlimited)
 	// TODO: model - preferred model to use (agent can ignore, but orchestrator can suggest)
 	// TODO: env - key/value pairs fo ragent specific environment config
 }
 
 // TaskMessage assigns work. One task per agent lifetime - the agent
 // processes this, sends complete, and exits.
+//
+// Files are slash-separated and relative to Repo. A directory covers
+// everything under it. In globs, "*" and "?" stay within one path
+// element and "**" spans any number of them; all patterns are anchored
+// at Repo, so "*.go" is only the top-level Go files.
 type TaskMessage struct {
 	Type string `json:"type"` // always "task"
 	Version int `json:"v"` // protocol version
@@ -34,9 +68,9 @@ type TaskMessage struct {
 	Prompt string `json:"prompt"` // what the agent should do
 	Repo string `json:"repo"` // working directory
 	Spec string `json:"spec,omitempty"` // optional path to a spec file
-	// TODO: context - prior failure output for test-driven supervision retries
-	// TODO: files - list of files the agent should focus on (narrows scope)
-	// TODO: depends_on - IDs of tasks that must complete first (for futur task graph)
+	DependsOn []string `json:"depends_on,omitempty"` // IDs of tasks that completed before this one, for context
+	Context string `json:"context,omitempty"` // background for the prompt: what upstream tasks did, why the last attempt failed
+	Files []string `json:"files,omitempty"` // paths and globs the agent may change; anything else is out of scope
 }
 
 // CancelMessage requests graceful shutdown of the current task.
@@ -59,6 +93,19 @@ type AnswerMessage struct {
 
 // --- Agent -> Orchestrator messages ---
 
+// HelloMessage is the agent's opening line, sent unprompted as soon as
+// it starts. It lists every protocol version the agent can speak so the
+// orchestrator can pick one (see Negotiate) before sending InitMessage.
+//
+// Hello is optional for agents unless the orchestrator requires a
+// handshake; its own shape never changes between versions.
+type HelloMessage struct {
+	Type string `json:"type"` // always "hello"
+	Version int `json:"v"` // protocol version the hello itself is written in
+	Versions []int `json:"versions"` // every version the agent supports
+	Agent string `json:"agent,omitempty"` // free-form name/version of the agent, for logs
+}
+
 // Heartbeat message is the agent's periodic "I'm alive and here's what
 // I'm doing signal. Combines progress info and resource usage into one message
 //
@@ -78,8 +125,9 @@ type HeartbeatMessage struct {
 	TokensIn int `json:"tokens_in"`
 	TokensOut int `json:"tokens_out"`
 	ElapsedS float64 `json:"elapsed_s"`
-	// TODO: cost_usd - agent-reported cost so far (agent knows the model + pricing)
-	// TODO: files_touched - files modified since last heartbeat (enables live monitoring)
+	Model string `json:"model,omitempty"` // model the tokens were spent on, for pricing
+	CostUSD float64 `json:"cost_usd,omitempty"` // cost so far, if the agent knows it
+	FilesTouched []string `json:"files_touched,omitempty"` // files modified since the last heartbeat, as the agent tells it
 }
 
 // BlockedMessage signals that the agents needs human input to proceed.
@@ -108,6 +156,61 @@ type CompleteMessage struct {
 	TokensIn int `json:"tokens_in"` // final totals
 	TokensOut int `json:"tokens_out"`
 	ElapsedS float64 `json:"elapsed_s"`
-	// TODO: cost_usd - total cost for the task
+	Model string `json:"model,omitempty"`
+	CostUSD float64 `json:"cost_usd,omitempty"` // total cost for the task, if the agent knows it
 	// TODO: exit_code - agent's self-reported exit status (separate from OS exit code)
 }
+
+// ChunkMessage carries one piece of a message too big to send as a
+// single line (see DefaultMaxMessageBytes) - a CompleteMessage with a
+// whole diff in its Summary, say. The agent JSON-encodes the real
+// message, splits the encoding into Data pieces on character
+// boundaries, and sends them in order under one ID, with More set on
+// all but the last. Decoder puts them back together and returns the
+// real message; callers never see a chunk.
+type ChunkMessage struct {
+	Type string `json:"type"` // always "chunk"
+	Version int `json:"v"` // protocol version
+	ID string `json:"id"` // identifies the chunked message, not the task
+	Seq int `json:"seq"` // 0, 1, 2, ... within ID
+	Data string `json:"data"` // next piece of the encoded message
+	More bool `json:"more,omitempty"` // false on the last chunk
+}
+
+// --- Message implementations ---
+
+func (m InitMessage) MessageType() string { return TypeInit }
+func (m InitMessage) ProtocolVersion() int { return m.Version }
+func (m InitMessage) stamp(v int) Message { m.Type, m.Version = TypeInit, v; return m }
+
+func (m TaskMessage) MessageType() string { return TypeTask }
+func (m TaskMessage) ProtocolVersion() int { return m.Version }
+func (m TaskMessage) stamp(v int) Message { m.Type, m.Version = TypeTask, v; return m }
+
+func (m CancelMessage) MessageType() string { return TypeCancel }
+func (m CancelMessage) ProtocolVersion() int { return m.Version }
+func (m CancelMessage) stamp(v int) Message { m.Type, m.Version = TypeCancel, v; return m }
+
+func (m AnswerMessage) MessageType() string { return TypeAnswer }
+func (m AnswerMessage) ProtocolVersion() int { return m.Version }
+func (m AnswerMessage) stamp(v int) Message { m.Type, m.Version = TypeAnswer, v; return m }
+
+func (m HeartbeatMessage) MessageType() string { return TypeHeartbeat }
+func (m HeartbeatMessage) ProtocolVersion() int { return m.Version }
+func (m HeartbeatMessage) stamp(v int) Message { m.Type, m.Version = TypeHeartbeat, v; return m }
+
+func (m BlockedMessage) MessageType() string { return TypeBlocked }
+func (m BlockedMessage) ProtocolVersion() int { return m.Version }
+func (m BlockedMessage) stamp(v int) Message { m.Type, m.Version = TypeBlocked, v; return m }
+
+func (m CompleteMessage) MessageType() string { return TypeComplete }
+func (m CompleteMessage) ProtocolVersion() int { return m.Version }
+func (m CompleteMessage) stamp(v int) Message { m.Type, m.Version = TypeComplete, v; return m }
+
+func (m HelloMessage) MessageType() string { return TypeHello }
+func (m HelloMessage) ProtocolVersion() int { return m.Version }
+func (m HelloMessage) stamp(v int) Message { m.Type, m.Version = TypeHello, v; return m }
+
+func (m ChunkMessage) MessageType() string { return TypeChunk }
+func (m ChunkMessage) ProtocolVersion() int { return m.Version }
+func (m ChunkMessage) stamp(v int) Message { m.Type, m.Version = TypeChunk, v; return m }
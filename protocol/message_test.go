@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -19,6 +20,9 @@ func TestTaskMessageRoundTrip(t *testing.T) {
 		Prompt: "add JWT auth to login endpoint",
 		Repo: "/home/thomas/projects/foo",
 		Spec: "specs/auth-login.md",
+		DependsOn: []string{"task-000"},
+		Context: "task-000 added the users table",
+		Files: []string{"internal/auth/", "*.md"},
 	}
 
 	data, err := json.Marshal(original)
@@ -31,7 +35,7 @@ func TestTaskMessageRoundTrip(t *testing.T) {
 		t.Fatalf("unmarshal failed: %v", err)
 	}
 
-	if decoded != original {
+	if !reflect.DeepEqual(decoded, original) {
 		t.Errorf("round trip failed\ngot: %+v\nwant: %+v", decoded, original)
 	}
 }
@@ -83,6 +87,9 @@ func TestHeartbeatMessageRoundTrip(t *testing.T) {
 		TokensIn: 12000,
 		TokensOut: 3400,
 		ElapsedS: 180.0,
+		Model: "claude-sonnet",
+		CostUSD: 0.42,
+		FilesTouched: []string{"internal/auth/login.go"},
 	}
 
 	data, err := json.Marshal(original)
@@ -95,7 +102,7 @@ func TestHeartbeatMessageRoundTrip(t *testing.T) {
 		t.Fatalf("unmarshal failed: %v", err)
 	}
 
-	if decoded != original {
+	if !reflect.DeepEqual(decoded, original) {
 		t.Errorf("round trip failed\ngot: %+v\nwant: %+v", decoded, original)
 	}
 }
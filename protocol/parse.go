@@ -24,62 +24,76 @@ type envelope struct {
 // case *CompleteMessage:
 // 		// handle completion
 // }
-func ParseMessage(data []byte) (interface{}, error) {
+func ParseMessage(data []byte) (Message, error) {
 	var env envelope
 	if err := json.Unmarshal(data, &env); err != nil {
 		return nil, fmt.Errorf("invalid JSON: %w", err)
 	}
 
 	switch env.Type {
-	case "init":
+	case TypeInit:
 		var msg InitMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("invalid init message: %w", err)
 		}
 		return &msg, nil
 
-	case "task":
+	case TypeTask:
 		var msg TaskMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("invalid task message: %w", err)
 		}
 		return &msg, nil
 
-	case "cancel":
+	case TypeCancel:
 		var msg CancelMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("invalid cancel message: %w", err)
 		}
 		return &msg, nil
 
-	case "answer":
+	case TypeAnswer:
 		var msg AnswerMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("invalid answer message: %w", err)
 		}
 		return &msg, nil
 
-	case "heartbeat":
+	case TypeHeartbeat:
 		var msg HeartbeatMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("invalid heartbeat message: %w", err)
 		}
 		return &msg, nil
 
-	case "blocked":
+	case TypeBlocked:
 		var msg BlockedMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("invalid blocked message: %w", err)
 		}
 		return &msg, nil
 
-	case "complete":
+	case TypeComplete:
 		var msg CompleteMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
 			return nil, fmt.Errorf("invalid complete message: %w", err)
 		}
 		return &msg, nil
 
+	case TypeHello:
+		var msg HelloMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("invalid hello message: %w", err)
+		}
+		return &msg, nil
+
+	case TypeChunk:
+		var msg ChunkMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("invalid chunk message: %w", err)
+		}
+		return &msg, nil
+
 	case "":
 		return nil, fmt.Errorf("missing message type")
 
@@ -87,3 +101,15 @@ func ParseMessage(data []byte) (interface{}, error) {
 		return nil, fmt.Errorf("unknown message type: %q", env.Type)
 	}
 }
+
+// ParseMessageVersion is ParseMessage for a conversation that settled on
+// protocol version v. Messages written in an older version are upgraded
+// to v where possible; anything else claiming a different version is
+// rejected with an error wrapping ErrIncompatibleVersion.
+func ParseMessageVersion(data []byte, v int) (Message, error) {
+	msg, err := ParseMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return Upgrade(msg, v)
+}
@@ -40,6 +40,11 @@ func TestParseMessageDispatchesCorrectType(t *testing.T) {
 			`{"type":"blocked","v":1,"id":"t1","question":"should I?"}`,
 			"blocked",
 		},
+		{
+			"hello message",
+			`{"type":"hello","v":1,"versions":[1,2],"agent":"fake/0.1"}`,
+			"hello",
+		},
 		{
 			"complete message done",
 			`{"type":"complete","v":1,"id":"t1","state":"done","summary":"finished","tokens_in":100,"tokens_out":50,"elapsed_s":10}`,
@@ -207,7 +212,7 @@ func TestParseMessageIgnoresUnknownFields(t *testing.T) {
 
 // typeOf is a test helper that returns the message type string for
 // assertion purposes. Keeps the test table clean.
-func typeOf(msg interface{}) string {
+func typeOf(msg Message) string {
 	switch msg.(type) {
 	case *InitMessage:
 		return "init"
@@ -223,6 +228,8 @@ func typeOf(msg interface{}) string {
 		return "blocked"
 	case *CompleteMessage:
 		return "complete"
+	case *HelloMessage:
+		return "hello"
 	default:
 		return "unknown"
 	}
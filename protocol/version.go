@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MinProtocolVersion is the oldest protocol version this package still
+// speaks. Together with ProtocolVersion it bounds what Negotiate will
+// agree to.
+const MinProtocolVersion = 1
+
+// ErrIncompatibleVersion is wrapped by every version mismatch: a failed
+// negotiation, or a message written in a version we can't handle.
+var ErrIncompatibleVersion = errors.New("incompatible protocol version")
+
+// upgrades maps a version to the function that rewrites a message of
+// that version into the next one. When ProtocolVersion is bumped, the
+// step from the previous version goes here.
+var upgrades = map[int]func(Message) (Message, error){}
+
+// Supported reports whether this package can speak version v.
+func Supported(v int) bool {
+	return v >= MinProtocolVersion && v <= ProtocolVersion
+}
+
+// Negotiate picks the highest version both sides support, given the
+// versions the agent offered in its HelloMessage.
+func Negotiate(offered []int) (int, error) {
+	best := 0
+	for _, v := range offered {
+		if Supported(v) && v > best {
+			best = v
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("%w: agent speaks %v, we speak %d through %d",
+			ErrIncompatibleVersion, offered, MinProtocolVersion, ProtocolVersion)
+	}
+	return best, nil
+}
+
+// Upgrade returns msg as a version v message. A message already at v is
+// returned unchanged, as is a HelloMessage, whose shape never changes.
+// Older messages are upgraded one version at a time; a message that is
+// newer than v, or has no upgrade path, is rejected.
+func Upgrade(msg Message, v int) (Message, error) {
+	if _, ok := msg.(*HelloMessage); ok {
+		return msg, nil
+	}
+	for msg.ProtocolVersion() != v {
+		from := msg.ProtocolVersion()
+		step, ok := upgrades[from]
+		if !ok || from > v {
+			return nil, fmt.Errorf("%w: %s message is v%d, expected v%d",
+				ErrIncompatibleVersion, msg.MessageType(), from, v)
+		}
+		next, err := step(msg)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade %s message from v%d: %w", msg.MessageType(), from, err)
+		}
+		msg = next
+	}
+	return msg, nil
+}
+
+// checkSupported rejects a message whose version this package doesn't
+// speak at all. Used before a version has been negotiated.
+func checkSupported(msg Message) error {
+	if _, ok := msg.(*HelloMessage); ok {
+		return nil
+	}
+	if v := msg.ProtocolVersion(); !Supported(v) {
+		return fmt.Errorf("%w: %s message is v%d, we speak %d through %d",
+			ErrIncompatibleVersion, msg.MessageType(), v, MinProtocolVersion, ProtocolVersion)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNegotiatePicksHighestCommonVersion(t *testing.T) {
+	v, err := Negotiate([]int{0, ProtocolVersion, ProtocolVersion + 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v != ProtocolVersion {
+		t.Errorf("negotiated v%d, want v%d", v, ProtocolVersion)
+	}
+}
+
+func TestNegotiateFailsWithoutCommonVersion(t *testing.T) {
+	for _, offered := range [][]int{nil, {}, {ProtocolVersion + 6, ProtocolVersion + 7}} {
+		if _, err := Negotiate(offered); !errors.Is(err, ErrIncompatibleVersion) {
+			t.Errorf("Negotiate(%v) err = %v, want ErrIncompatibleVersion", offered, err)
+		}
+	}
+}
+
+func TestParseMessageVersionRejectsOtherVersions(t *testing.T) {
+	input := `{"type":"complete","v":7,"id":"t1","state":"done","tokens_in":1,"tokens_out":1,"elapsed_s":1}`
+
+	// Plain ParseMessage stays lenient...
+	if _, err := ParseMessage([]byte(input)); err != nil {
+		t.Fatalf("ParseMessage: %v", err)
+	}
+	// ...but a conversation that settled on v1 won't take a v7 message
+	if _, err := ParseMessageVersion([]byte(input), 1); !errors.Is(err, ErrIncompatibleVersion) {
+		t.Errorf("err = %v, want ErrIncompatibleVersion", err)
+	}
+}
+
+func TestDecoderRejectsUnsupportedVersions(t *testing.T) {
+	input := `{"type":"hello","v":1,"versions":[1,7]}
+{"type":"complete","v":7,"id":"t1","state":"done","tokens_in":1,"tokens_out":1,"elapsed_s":1}
+{"type":"complete","v":1,"id":"t1","state":"done","tokens_in":1,"tokens_out":1,"elapsed_s":1}
+`
+	dec := NewDecoder(strings.NewReader(input))
+
+	msg, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+	hello, ok := msg.(*HelloMessage)
+	if !ok {
+		t.Fatalf("first message = %T, want *HelloMessage", msg)
+	}
+	v, err := Negotiate(hello.Versions)
+	if err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+	dec.SetVersion(v)
+
+	if _, err := dec.Decode(); !errors.Is(err, ErrIncompatibleVersion) {
+		t.Errorf("v7 complete err = %v, want ErrIncompatibleVersion", err)
+	}
+	if _, err := dec.Decode(); err != nil {
+		t.Errorf("v1 complete err = %v, want nil", err)
+	}
+}
+
+func TestUpgradeAppliesRegisteredSteps(t *testing.T) {
+	// Pretend v1 messages are upgraded to a hypothetical v2 by
+	// renaming the task.
+	upgrades[1] = func(m Message) (Message, error) {
+		task := *m.(*TaskMessage)
+		task.Version = 2
+		task.ID = "upgraded-" + task.ID
+		return &task, nil
+	}
+	defer delete(upgrades, 1)
+
+	msg, err := Upgrade(&TaskMessage{Version: 1, ID: "t1"}, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if task := msg.(*TaskMessage); task.Version != 2 || task.ID != "upgraded-t1" {
+		t.Errorf("upgraded message = %+v", task)
+	}
+
+	if _, err := Upgrade(&TaskMessage{Version: 2}, 1); !errors.Is(err, ErrIncompatibleVersion) {
+		t.Errorf("downgrade err = %v, want ErrIncompatibleVersion", err)
+	}
+}
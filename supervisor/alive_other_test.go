@@ -0,0 +1,16 @@
+//go:build !unix
+
+package supervisor
+
+import "os"
+
+// alive reports whether pid is still running, as far as finding it can
+// tell without Unix signals.
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package supervisor
+
+import "syscall"
+
+// alive reports whether pid is still running.
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
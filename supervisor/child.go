@@ -0,0 +1,184 @@
+package supervisor
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// child is the live state of one ChildSpec. Only the supervisor's Run
+// loop changes it; mu makes it safe to inspect from elsewhere.
+//
+// A child is either an OS process (cmd) or a nested supervisor running
+// on its own goroutine (cancel stops it).
+type child struct {
+	spec  ChildSpec
+	index int // position in the supervisor's start order
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	cancel   context.CancelFunc
+	gen      int           // bumped on every start, so stale exits can be ignored
+	done     chan struct{} // closed when the current process has exited
+	running  bool
+	restarts int
+
+	started     time.Time // when the current process was started
+	consecutive int       // restarts in a row without a healthy run, for backoff
+}
+
+// exit reports that a child's process ended.
+type exit struct {
+	index int
+	gen   int
+	err   error // nil for a clean exit
+}
+
+// start starts the child. Its exit is reported on exits unless quit is
+// closed first.
+//
+// A nested supervisor counts as started once it has started all of its
+// own children, so a whole tree comes up in a deterministic order.
+func (c *child) start(exits chan<- exit, quit <-chan struct{}) error {
+	var cmd *exec.Cmd
+	var cancel context.CancelFunc
+	var wait func() error
+
+	if sup := c.spec.Supervisor; sup != nil {
+		ctx, stop := context.WithCancel(context.Background())
+		ready := make(chan struct{})
+		result := make(chan error, 1)
+		go func() {
+			result <- sup.run(ctx, ready)
+		}()
+		select {
+		case <-ready:
+		case err := <-result:
+			stop()
+			return err
+		}
+		cancel = stop
+		wait = func() error { return <-result }
+	} else {
+		cmd = exec.Command(c.spec.Command, c.spec.Args...)
+		cmd.Env = c.spec.Env
+		cmd.Dir = c.spec.Dir
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		wait = cmd.Wait
+	}
+
+	c.mu.Lock()
+	c.cmd = cmd
+	c.cancel = cancel
+	c.gen++
+	c.done = make(chan struct{})
+	c.running = true
+	c.started = time.Now()
+	gen, done := c.gen, c.done
+	c.mu.Unlock()
+
+	go func() {
+		err := wait()
+		close(done)
+		select {
+		case exits <- exit{index: c.index, gen: gen, err: err}:
+		case <-quit:
+		}
+	}()
+	return nil
+}
+
+// exited marks the child as no longer running if ev is about its
+// current process. It reports false for stale exits, including the
+// exits of processes the supervisor stopped itself.
+func (c *child) exited(ev exit) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ev.gen != c.gen {
+		return false
+	}
+	c.running = false
+	return true
+}
+
+// failed records a failed start as an immediate abnormal exit, so the
+// normal restart logic applies to it.
+func (c *child) failed(err error, exits chan<- exit, quit <-chan struct{}) {
+	c.mu.Lock()
+	c.gen++
+	c.started = time.Now()
+	ev := exit{index: c.index, gen: c.gen, err: err}
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case exits <- ev:
+		case <-quit:
+		}
+	}()
+}
+
+// stop shuts the child down: SIGTERM, then SIGKILL once the spec's
+// shutdown timeout runs out. A nested supervisor is asked to shut down
+// its own tree and given as long as it needs. stop returns once the
+// child has exited.
+func (c *child) stop() {
+	c.mu.Lock()
+	running := c.running
+	c.running = false
+	c.gen++ // whatever exit is pending, we caused it
+	c.mu.Unlock()
+	if !running {
+		return
+	}
+
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+		return
+	}
+
+	if c.spec.Shutdown > 0 {
+		if err := c.cmd.Process.Signal(syscall.SIGTERM); err == nil {
+			timer := time.NewTimer(c.spec.Shutdown)
+			defer timer.Stop()
+			select {
+			case <-c.done:
+				return
+			case <-timer.C:
+			}
+		}
+	}
+	c.cmd.Process.Kill()
+	<-c.done
+}
+
+// currentGen returns the child's generation.
+func (c *child) currentGen() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// info snapshots the child for WhichChildren.
+func (c *child) info() ChildInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := ChildInfo{
+		Name:       c.spec.Name,
+		Supervisor: c.spec.Supervisor != nil,
+		Running:    c.running,
+		Restarts:   c.restarts,
+	}
+	if c.running {
+		info.Started = c.started
+		if c.cmd != nil {
+			info.PID = c.cmd.Process.Pid
+		}
+	}
+	return info
+}
@@ -0,0 +1,108 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// DefaultPeriod is the restart intensity window used when
+// Config.Period is zero.
+const DefaultPeriod = 5 * time.Second
+
+// historyLimit bounds how many restarts a supervisor remembers, unless
+// MaxRestarts needs more to be checked against.
+const historyLimit = 100
+
+// ErrRestartIntensity is wrapped by the error Run returns when children
+// restarted too often and the supervisor gave up.
+var ErrRestartIntensity = errors.New("restart intensity exceeded")
+
+// Backoff spaces out consecutive restarts of a child. The n-th restart
+// in a row waits Initial * Multiplier^(n-1), capped at Max and spread by
+// Jitter. A child that stays up for the supervisor's whole Period is
+// considered healthy again and its next restart starts from Initial.
+type Backoff struct {
+	Initial    time.Duration // delay before the first restart (0 = restart immediately)
+	Max        time.Duration // upper bound on the delay (0 = unbounded)
+	Multiplier float64       // growth per consecutive restart (0 = 2)
+	Jitter     float64       // random spread as a fraction of the delay, 0-1
+}
+
+// delay returns how long to wait before restart number n (0-based) in
+// a row.
+func (b Backoff) delay(n int) time.Duration {
+	if b.Initial <= 0 {
+		return 0
+	}
+	mult := b.Multiplier
+	if mult == 0 {
+		mult = 2
+	}
+
+	d := float64(b.Initial)
+	for i := 0; i < n; i++ {
+		d *= mult
+		if b.Max > 0 && d >= float64(b.Max) {
+			break
+		}
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		// Spread evenly over d ± Jitter*d/2
+		d += (rand.Float64() - 0.5) * b.Jitter * d
+	}
+	return time.Duration(d)
+}
+
+// RestartEvent records one restart decision.
+type RestartEvent struct {
+	Child  string        // name of the child that exited
+	Time   time.Time     // when the exit was handled
+	Reason error         // how it exited (nil = clean exit)
+	Delay  time.Duration // backoff applied before restarting
+}
+
+func (e RestartEvent) String() string {
+	reason := "exited normally"
+	if e.Reason != nil {
+		reason = e.Reason.Error()
+	}
+	return fmt.Sprintf("%s %s: %s", e.Time.Format(time.RFC3339Nano), e.Child, reason)
+}
+
+// IntensityError is returned by Run when more than MaxRestarts restarts
+// happened within Period. History holds those restarts, oldest first.
+type IntensityError struct {
+	MaxRestarts int
+	Period      time.Duration
+	History     []RestartEvent
+}
+
+func (e *IntensityError) Error() string {
+	msg := fmt.Sprintf("%d restarts in %s, limit is %d",
+		len(e.History), e.Period, e.MaxRestarts)
+	if n := len(e.History); n > 0 {
+		msg += fmt.Sprintf(" (last: %s)", e.History[n-1])
+	}
+	return fmt.Sprintf("%v: %s", ErrRestartIntensity, msg)
+}
+
+func (e *IntensityError) Unwrap() error {
+	return ErrRestartIntensity
+}
+
+// recentRestarts returns the events in history that happened within
+// period before now.
+func recentRestarts(history []RestartEvent, period time.Duration, now time.Time) []RestartEvent {
+	cutoff := now.Add(-period)
+	for i, ev := range history {
+		if ev.Time.After(cutoff) {
+			return history[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIntensityExceededStopsChildrenAndFails(t *testing.T) {
+	b := crash("b", Permanent)
+	b.Backoff = Backoff{Initial: 20 * time.Millisecond, Max: 20 * time.Millisecond}
+	sup := New(Config{
+		Strategy:    OneForOne,
+		Children:    []ChildSpec{hang("a"), b},
+		MaxRestarts: 3,
+		Period:      10 * time.Second,
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sup.Run(context.Background())
+	}()
+	witness := startedPIDs(t, sup, "a")["a"]
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("breaker never tripped")
+	}
+	if !errors.Is(err, ErrRestartIntensity) {
+		t.Fatalf("err = %v, want ErrRestartIntensity", err)
+	}
+
+	var ie *IntensityError
+	if !errors.As(err, &ie) {
+		t.Fatalf("err = %T, want *IntensityError", err)
+	}
+	if len(ie.History) != 4 {
+		t.Errorf("history has %d restarts, want 4", len(ie.History))
+	}
+	for _, ev := range ie.History {
+		if ev.Child != "b" || ev.Reason == nil {
+			t.Errorf("history event %v, want an abnormal exit of b", ev)
+		}
+	}
+
+	for _, info := range sup.WhichChildren() {
+		if info.Running {
+			t.Errorf("%s still running after the breaker tripped", info.Name)
+		}
+	}
+	if alive(witness) {
+		t.Errorf("a (pid %d) is still alive after the breaker tripped", witness)
+	}
+}
+
+func TestIntensityAboveHistoryLimitTrips(t *testing.T) {
+	sup := New(Config{
+		Strategy:    OneForOne,
+		Children:    []ChildSpec{crash("a", Permanent)},
+		MaxRestarts: historyLimit + 50,
+		Period:      time.Hour,
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sup.Run(context.Background())
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-time.After(30 * time.Second):
+		t.Fatal("breaker never tripped")
+	}
+	var ie *IntensityError
+	if !errors.As(err, &ie) {
+		t.Fatalf("err = %v, want *IntensityError", err)
+	}
+	if len(ie.History) != historyLimit+51 {
+		t.Errorf("History has %d restarts, want %d", len(ie.History), historyLimit+51)
+	}
+}
+
+func TestBackoffSpacesOutRestarts(t *testing.T) {
+	spec := crash("b", Permanent)
+	spec.Backoff = Backoff{Initial: 50 * time.Millisecond, Max: 200 * time.Millisecond}
+	sup := New(Config{
+		Children:    []ChildSpec{spec},
+		MaxRestarts: 4,
+		Period:      10 * time.Second,
+	})
+
+	start := time.Now()
+	if err := sup.Run(context.Background()); !errors.Is(err, ErrRestartIntensity) {
+		t.Fatalf("err = %v, want ErrRestartIntensity", err)
+	}
+
+	// Four restarts were waited out before the fifth tripped the breaker
+	if elapsed := time.Since(start); elapsed < 550*time.Millisecond {
+		t.Errorf("breaker tripped after %s, want at least 50+100+200+200ms", elapsed)
+	}
+
+	want := []time.Duration{50, 100, 200, 200}
+	history := sup.History()
+	if len(history) != 5 {
+		t.Fatalf("history has %d restarts, want 5", len(history))
+	}
+	for i, d := range want {
+		if got := history[i].Delay; got != d*time.Millisecond {
+			t.Errorf("restart %d delay = %s, want %s", i, got, d*time.Millisecond)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Multiplier: 3, Max: time.Second}
+	want := []time.Duration{100, 300, 900, 1000, 1000}
+	for n, w := range want {
+		if got := b.delay(n); got != w*time.Millisecond {
+			t.Errorf("delay(%d) = %s, want %s", n, got, w*time.Millisecond)
+		}
+	}
+
+	if got := (Backoff{}).delay(5); got != 0 {
+		t.Errorf("zero Backoff delay = %s, want 0", got)
+	}
+
+	jittered := Backoff{Initial: time.Second, Jitter: 0.5}
+	for range 100 {
+		if got := jittered.delay(0); got < 750*time.Millisecond || got > 1250*time.Millisecond {
+			t.Fatalf("jittered delay = %s, want within 1s ± 250ms", got)
+		}
+	}
+}
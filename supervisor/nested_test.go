@@ -0,0 +1,146 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNestedSupervisorEscalatesToParent(t *testing.T) {
+	// One subtree per repository; repo-b's agent pool keeps crashing
+	repoA := New(Config{Children: []ChildSpec{hang("a1"), hang("a2")}})
+	repoB := New(Config{
+		Children:    []ChildSpec{hang("b1"), crash("b2", Permanent)},
+		MaxRestarts: 2,
+		Period:      10 * time.Second,
+	})
+	root := New(Config{
+		Strategy: OneForOne,
+		Children: []ChildSpec{
+			{Name: "repo-a", Supervisor: repoA},
+			{Name: "repo-b", Supervisor: repoB},
+		},
+		MaxRestarts: 2,
+		Period:      10 * time.Second,
+	})
+
+	err := root.Run(context.Background())
+	if !errors.Is(err, ErrRestartIntensity) {
+		t.Fatalf("err = %v, want ErrRestartIntensity", err)
+	}
+
+	// The root gave up because repo-b kept giving up
+	var ie *IntensityError
+	if !errors.As(err, &ie) {
+		t.Fatalf("err = %T, want *IntensityError", err)
+	}
+	for _, ev := range ie.History {
+		var sub *IntensityError
+		if ev.Child != "repo-b" || !errors.As(ev.Reason, &sub) {
+			t.Errorf("root restart %v, want repo-b exiting with its own intensity error", ev)
+		}
+	}
+
+	if repoA.WhichChildren()[0].Restarts != 0 {
+		t.Error("repo-a's children were restarted, want them left alone by one_for_one")
+	}
+	for _, sup := range []*Supervisor{root, repoA, repoB} {
+		for _, info := range sup.WhichChildren() {
+			if info.Running {
+				t.Errorf("%s still running after the root gave up", info.Name)
+			}
+		}
+	}
+}
+
+func TestNestedStartupOrderIsDeterministic(t *testing.T) {
+	first := New(Config{Children: []ChildSpec{hang("f1"), hang("f2")}})
+	second := New(Config{Children: []ChildSpec{hang("s1"), hang("s2")}})
+	root := New(Config{Children: []ChildSpec{
+		{Name: "first", Supervisor: first},
+		{Name: "second", Supervisor: second},
+	}})
+	stop := runSupervisor(t, root)
+	defer stop()
+
+	waitFor(t, "the tree to start", func() bool {
+		for _, info := range root.WhichChildren() {
+			if !info.Running {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Every child of the first subtree is up before the second subtree
+	// starts anything.
+	var order []time.Time
+	for _, sup := range []*Supervisor{first, second} {
+		for _, info := range sup.WhichChildren() {
+			order = append(order, info.Started)
+		}
+	}
+	for i := 1; i < len(order); i++ {
+		if order[i].Before(order[i-1]) {
+			t.Fatalf("start times out of order: %v", order)
+		}
+	}
+}
+
+func TestNestedShutdownRunsInReverseOrder(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to log shutdown order")
+	}
+
+	log := filepath.Join(t.TempDir(), "stops")
+	// logger appends its name to log when asked to stop
+	logger := func(name string) ChildSpec {
+		script := `trap 'echo ` + name + ` >> "$LOG"; exit 0' TERM; while :; do sleep 0.01; done`
+		return ChildSpec{
+			Name:     name,
+			Command:  sh,
+			Args:     []string{"-c", script},
+			Env:      []string{"LOG=" + log},
+			Shutdown: 5 * time.Second,
+		}
+	}
+
+	left := New(Config{Children: []ChildSpec{logger("l1"), logger("l2")}})
+	right := New(Config{Children: []ChildSpec{logger("r1"), logger("r2")}})
+	root := New(Config{Children: []ChildSpec{
+		logger("top"),
+		{Name: "left", Supervisor: left},
+		{Name: "right", Supervisor: right},
+	}})
+	stop := runSupervisor(t, root)
+
+	waitFor(t, "the tree to start", func() bool {
+		for _, info := range root.WhichChildren() {
+			if !info.Running {
+				return false
+			}
+		}
+		return true
+	})
+	// Give the shells a moment to install their traps
+	time.Sleep(100 * time.Millisecond)
+	if err := stop(); err != nil {
+		t.Fatalf("Run returned %v, want nil", err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("read stop log: %v", err)
+	}
+	got := strings.Fields(string(data))
+	want := []string{"r2", "r1", "l2", "l1", "top"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("stop order = %v, want %v", got, want)
+	}
+}
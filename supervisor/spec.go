@@ -0,0 +1,108 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Restart says whether a child should be restarted when it exits.
+type Restart int
+
+const (
+	// Permanent children are always restarted.
+	Permanent Restart = iota
+	// Transient children are restarted only after an abnormal exit:
+	// a non-zero exit status or death by signal.
+	Transient
+	// Temporary children are never restarted.
+	Temporary
+)
+
+func (r Restart) String() string {
+	switch r {
+	case Permanent:
+		return "permanent"
+	case Transient:
+		return "transient"
+	case Temporary:
+		return "temporary"
+	default:
+		return fmt.Sprintf("Restart(%d)", int(r))
+	}
+}
+
+// Strategy says which children are restarted when one of them exits.
+type Strategy int
+
+const (
+	// OneForOne restarts only the child that exited.
+	OneForOne Strategy = iota
+	// OneForAll stops every other child and restarts them all.
+	OneForAll
+	// RestForOne stops and restarts the exited child and every child
+	// started after it.
+	RestForOne
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case OneForOne:
+		return "one_for_one"
+	case OneForAll:
+		return "one_for_all"
+	case RestForOne:
+		return "rest_for_one"
+	default:
+		return fmt.Sprintf("Strategy(%d)", int(s))
+	}
+}
+
+// ChildSpec is a recipe for starting and restarting one OS process, or
+// a whole subtree when Supervisor is set.
+//
+// A nested supervisor that gives up (its restart intensity is exceeded)
+// exits abnormally, so its parent restarts it like any crashed child and
+// counts that against its own restart intensity. Command, Args, Env, Dir
+// and Shutdown don't apply to supervisor children.
+type ChildSpec struct {
+	Name       string      // unique within the supervisor
+	Supervisor *Supervisor // run this supervisor as the child instead of a process
+	Command    string      // path to the executable
+	Args       []string    // arguments, not including the command itself
+	Env        []string    // environment as "KEY=value" (nil = inherit)
+	Dir        string      // working directory ("" = inherit)
+	Restart    Restart
+	Shutdown   time.Duration // grace between SIGTERM and SIGKILL (0 = SIGKILL straight away)
+	Backoff    Backoff       // delay between consecutive restarts
+}
+
+// validate checks a list of child specs before anything is started.
+func validate(specs []ChildSpec) error {
+	seen := make(map[string]bool, len(specs))
+	for i, spec := range specs {
+		if spec.Name == "" {
+			return fmt.Errorf("child %d: missing name", i)
+		}
+		if seen[spec.Name] {
+			return fmt.Errorf("child %q: duplicate name", spec.Name)
+		}
+		seen[spec.Name] = true
+		switch {
+		case spec.Supervisor != nil && spec.Command != "":
+			return fmt.Errorf("child %q: both command and supervisor set", spec.Name)
+		case spec.Supervisor == nil && spec.Command == "":
+			return fmt.Errorf("child %q: missing command", spec.Name)
+		}
+		if spec.Shutdown < 0 {
+			return fmt.Errorf("child %q: negative shutdown timeout", spec.Name)
+		}
+		if spec.Backoff.Jitter < 0 || spec.Backoff.Jitter > 1 {
+			return fmt.Errorf("child %q: backoff jitter must be between 0 and 1", spec.Name)
+		}
+	}
+	return nil
+}
+
+// ErrAlreadyRunning is returned by Run if the supervisor is already running.
+var ErrAlreadyRunning = errors.New("supervisor already running")
@@ -0,0 +1,282 @@
+// Package supervisor implements Erlang/OTP-style supervision of
+// long-running OS processes.
+//
+// A Supervisor starts its children in order, watches them, and when one
+// exits applies the child's Restart type and the supervisor's Strategy
+// to decide what to restart. Children are stopped in reverse start order.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Config describes a supervisor: its restart strategy, its restart
+// intensity, and its children, in start order.
+//
+// Restart intensity is a circuit breaker: if more than MaxRestarts
+// restarts happen within Period, the supervisor stops all its children
+// and Run returns an *IntensityError.
+type Config struct {
+	Strategy    Strategy
+	Children    []ChildSpec
+	MaxRestarts int           // restart intensity (0 = unlimited)
+	Period      time.Duration // intensity window (0 = DefaultPeriod)
+}
+
+// ChildInfo is a point-in-time view of one child, as reported by
+// WhichChildren.
+type ChildInfo struct {
+	Name       string
+	Supervisor bool // a nested supervisor rather than a process
+	PID        int  // 0 when not running, and always for supervisors
+	Running    bool
+	Started    time.Time // when the running child was (re)started
+	Restarts   int       // times this child was started after the first
+}
+
+// Supervisor watches a set of child processes and restarts them
+// according to its Config.
+type Supervisor struct {
+	config Config
+
+	mu       sync.Mutex
+	running  bool
+	children []*child
+	history  []RestartEvent
+
+	exits  chan exit
+	starts chan []scheduled
+	quit   chan struct{}
+}
+
+// scheduled is a child whose restart is waiting out a backoff delay.
+// gen is the child's generation when the restart was scheduled; if it
+// changed in the meantime, someone else already dealt with the child.
+type scheduled struct {
+	child *child
+	gen   int
+}
+
+// New creates a supervisor. Nothing is started until Run.
+func New(cfg Config) *Supervisor {
+	if cfg.Period == 0 {
+		cfg.Period = DefaultPeriod
+	}
+	return &Supervisor{config: cfg}
+}
+
+// Run starts every child in order and supervises them until ctx is
+// done, then stops them in reverse order and returns nil. If the restart
+// intensity is exceeded, the children are stopped the same way and Run
+// returns an *IntensityError.
+//
+// If a child can't be started initially, the children started before it
+// are stopped and Run returns the error. A supervisor can be Run again
+// after Run returns; every run starts from fresh children.
+func (s *Supervisor) Run(ctx context.Context) error {
+	return s.run(ctx, nil)
+}
+
+// run is Run, closing ready once every child has been started. That's
+// how a parent supervisor knows a nested one is up.
+func (s *Supervisor) run(ctx context.Context, ready chan<- struct{}) error {
+	if err := validate(s.config.Children); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return ErrAlreadyRunning
+	}
+	s.running = true
+	s.children = make([]*child, len(s.config.Children))
+	for i, spec := range s.config.Children {
+		s.children[i] = &child{spec: spec, index: i}
+	}
+	s.history = nil
+	s.exits = make(chan exit)
+	s.starts = make(chan []scheduled)
+	s.quit = make(chan struct{})
+	s.mu.Unlock()
+
+	defer func() {
+		close(s.quit)
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	for _, c := range s.children {
+		if err := c.start(s.exits, s.quit); err != nil {
+			s.stopAll()
+			return fmt.Errorf("start child %q: %w", c.spec.Name, err)
+		}
+	}
+	if ready != nil {
+		close(ready)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.stopAll()
+			return nil
+
+		case ev := <-s.exits:
+			c := s.children[ev.index]
+			if !c.exited(ev) {
+				continue
+			}
+			if !shouldRestart(c.spec.Restart, ev.err) {
+				continue
+			}
+			delay, err := s.recordRestart(c, ev.err)
+			if err != nil {
+				s.stopAll()
+				return err
+			}
+			s.restart(c, delay)
+
+		case due := <-s.starts:
+			for _, sc := range due {
+				if sc.child.currentGen() == sc.gen {
+					s.startAgain(sc.child)
+				}
+			}
+		}
+	}
+}
+
+// History returns the most recent restarts, oldest first.
+func (s *Supervisor) History() []RestartEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.history)
+}
+
+// recordRestart adds a restart of c to the history, works out its
+// backoff delay, and checks the restart intensity.
+func (s *Supervisor) recordRestart(c *child, reason error) (time.Duration, error) {
+	now := time.Now()
+	if now.Sub(c.started) >= s.config.Period {
+		c.consecutive = 0
+	}
+	delay := c.spec.Backoff.delay(c.consecutive)
+	c.consecutive++
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history, RestartEvent{
+		Child:  c.spec.Name,
+		Time:   now,
+		Reason: reason,
+		Delay:  delay,
+	})
+	// The intensity check needs MaxRestarts+1 restarts to trip on
+	if limit := max(historyLimit, s.config.MaxRestarts+1); len(s.history) > limit {
+		s.history = slices.Delete(s.history, 0, len(s.history)-limit)
+	}
+
+	if s.config.MaxRestarts > 0 {
+		recent := recentRestarts(s.history, s.config.Period, now)
+		if len(recent) > s.config.MaxRestarts {
+			return 0, &IntensityError{
+				MaxRestarts: s.config.MaxRestarts,
+				Period:      s.config.Period,
+				History:     slices.Clone(recent),
+			}
+		}
+	}
+	return delay, nil
+}
+
+// WhichChildren reports the state of every child, in start order.
+func (s *Supervisor) WhichChildren() []ChildInfo {
+	s.mu.Lock()
+	children := s.children
+	s.mu.Unlock()
+
+	infos := make([]ChildInfo, len(children))
+	for i, c := range children {
+		infos[i] = c.info()
+	}
+	return infos
+}
+
+// shouldRestart applies a child's Restart type to how it exited.
+func shouldRestart(r Restart, exitErr error) bool {
+	switch r {
+	case Permanent:
+		return true
+	case Transient:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// restart applies the strategy after c exited and needs restarting.
+// The affected children are stopped now and started again after delay.
+func (s *Supervisor) restart(c *child, delay time.Duration) {
+	var affected []*child
+	switch s.config.Strategy {
+	case OneForAll:
+		affected = s.children
+	case RestForOne:
+		affected = s.children[c.index:]
+	default:
+		affected = []*child{c}
+	}
+
+	// Stop the others in reverse start order, then bring everything
+	// back up in start order. Temporary children that had to be stopped
+	// stay stopped.
+	for i := len(affected) - 1; i >= 0; i-- {
+		affected[i].stop()
+	}
+	var due []scheduled
+	for _, a := range affected {
+		if a != c && a.spec.Restart == Temporary {
+			continue
+		}
+		due = append(due, scheduled{child: a, gen: a.currentGen()})
+	}
+
+	if delay <= 0 {
+		for _, sc := range due {
+			s.startAgain(sc.child)
+		}
+		return
+	}
+	starts, quit := s.starts, s.quit
+	time.AfterFunc(delay, func() {
+		select {
+		case starts <- due:
+		case <-quit:
+		}
+	})
+}
+
+// startAgain restarts a child. A child that can't be started is treated
+// as having crashed straight away, so the normal restart logic applies.
+func (s *Supervisor) startAgain(c *child) {
+	c.mu.Lock()
+	c.restarts++
+	c.mu.Unlock()
+
+	if err := c.start(s.exits, s.quit); err != nil {
+		c.failed(err, s.exits, s.quit)
+	}
+}
+
+// stopAll stops every child in reverse start order.
+func (s *Supervisor) stopAll() {
+	for i := len(s.children) - 1; i >= 0; i-- {
+		s.children[i].stop()
+	}
+}
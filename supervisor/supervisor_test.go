@@ -0,0 +1,253 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// agentBin returns the path to a compiled fake agent binary.
+func agentBin(name string) string {
+	abs, err := filepath.Abs(filepath.Join("testdata", "bin", name))
+	if err != nil {
+		panic(err)
+	}
+	return abs
+}
+
+func TestMain(m *testing.M) {
+	// The fake agents double as well-behaved (hang), crashing (crash)
+	// and cleanly exiting (happy) long-running processes.
+	agents := []string{"happy", "hang", "crash"}
+	for _, a := range agents {
+		cmd := exec.Command("go", "build", "-o",
+			filepath.Join("testdata", "bin", a),
+			filepath.Join("..", "testdata", "agents", a))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to build %s agent: %v\n", a, err)
+			os.Exit(1)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// runSupervisor runs sup in the background and returns a function that
+// stops it and returns Run's error.
+func runSupervisor(t *testing.T, sup *Supervisor) func() error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sup.Run(ctx)
+	}()
+	t.Cleanup(cancel)
+	return func() error {
+		cancel()
+		select {
+		case err := <-errCh:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatal("supervisor did not shut down")
+			return nil
+		}
+	}
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// childNamed returns the current info for the named child.
+func childNamed(sup *Supervisor, name string) ChildInfo {
+	for _, info := range sup.WhichChildren() {
+		if info.Name == name {
+			return info
+		}
+	}
+	return ChildInfo{}
+}
+
+func hang(name string) ChildSpec {
+	return ChildSpec{Name: name, Command: agentBin("hang"), Shutdown: time.Second}
+}
+
+func crash(name string, r Restart) ChildSpec {
+	return ChildSpec{Name: name, Command: agentBin("crash"), Restart: r}
+}
+
+// startedPIDs waits until every child is running and returns their PIDs.
+func startedPIDs(t *testing.T, sup *Supervisor, names ...string) map[string]int {
+	t.Helper()
+	pids := make(map[string]int)
+	waitFor(t, "children to start", func() bool {
+		for _, name := range names {
+			info := childNamed(sup, name)
+			if !info.Running {
+				return false
+			}
+			pids[name] = info.PID
+		}
+		return true
+	})
+	return pids
+}
+
+func TestOneForOneRestartsOnlyTheCrashedChild(t *testing.T) {
+	sup := New(Config{
+		Strategy: OneForOne,
+		Children: []ChildSpec{hang("a"), crash("b", Permanent), hang("c")},
+	})
+	stop := runSupervisor(t, sup)
+
+	before := startedPIDs(t, sup, "a", "c")
+	waitFor(t, "b to be restarted", func() bool {
+		return childNamed(sup, "b").Restarts >= 2
+	})
+	after := startedPIDs(t, sup, "a", "c")
+
+	for _, name := range []string{"a", "c"} {
+		if before[name] != after[name] {
+			t.Errorf("%s was restarted (pid %d -> %d), want untouched", name, before[name], after[name])
+		}
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Run returned %v, want nil", err)
+	}
+}
+
+func TestOneForAllRestartsEveryChild(t *testing.T) {
+	sup := New(Config{
+		Strategy: OneForAll,
+		Children: []ChildSpec{hang("a"), crash("b", Permanent)},
+	})
+	stop := runSupervisor(t, sup)
+	defer stop()
+
+	waitFor(t, "a to be restarted alongside b", func() bool {
+		return childNamed(sup, "a").Restarts >= 1
+	})
+}
+
+func TestRestForOneRestartsLaterChildren(t *testing.T) {
+	sup := New(Config{
+		Strategy: RestForOne,
+		Children: []ChildSpec{hang("a"), crash("b", Permanent), hang("c")},
+	})
+	stop := runSupervisor(t, sup)
+	defer stop()
+
+	before := startedPIDs(t, sup, "a")
+	waitFor(t, "c to be restarted after b", func() bool {
+		return childNamed(sup, "c").Restarts >= 1
+	})
+
+	a := childNamed(sup, "a")
+	if a.Restarts != 0 || a.PID != before["a"] {
+		t.Errorf("a = %+v, want it untouched with pid %d", a, before["a"])
+	}
+}
+
+func TestRestartTypes(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         ChildSpec
+		wantRestarts bool
+	}{
+		{"permanent clean exit", ChildSpec{Command: agentBin("happy"), Restart: Permanent}, true},
+		{"transient crash", ChildSpec{Command: agentBin("crash"), Restart: Transient}, true},
+		{"transient clean exit", ChildSpec{Command: agentBin("happy"), Restart: Transient}, false},
+		{"temporary crash", ChildSpec{Command: agentBin("crash"), Restart: Temporary}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Name = "x"
+			sup := New(Config{Children: []ChildSpec{tt.spec, hang("witness")}})
+			stop := runSupervisor(t, sup)
+			defer stop()
+
+			if tt.wantRestarts {
+				waitFor(t, "a restart", func() bool {
+					return childNamed(sup, "x").Restarts >= 1
+				})
+				return
+			}
+
+			waitFor(t, "the child to exit", func() bool {
+				return !childNamed(sup, "x").Running
+			})
+			time.Sleep(200 * time.Millisecond)
+			if x := childNamed(sup, "x"); x.Restarts != 0 || x.Running {
+				t.Errorf("child = %+v, want it left stopped", x)
+			}
+		})
+	}
+}
+
+func TestShutdownStopsEveryChild(t *testing.T) {
+	sup := New(Config{Children: []ChildSpec{hang("a"), hang("b"), hang("c")}})
+	stop := runSupervisor(t, sup)
+
+	pids := startedPIDs(t, sup, "a", "b", "c")
+	if err := stop(); err != nil {
+		t.Fatalf("Run returned %v, want nil", err)
+	}
+
+	for name, pid := range pids {
+		if alive(pid) {
+			t.Errorf("%s (pid %d) is still alive after shutdown", name, pid)
+		}
+	}
+	for _, info := range sup.WhichChildren() {
+		if info.Running {
+			t.Errorf("%s still reported running after shutdown", info.Name)
+		}
+	}
+}
+
+func TestRunFailsWhenAChildCannotStart(t *testing.T) {
+	sup := New(Config{Children: []ChildSpec{
+		hang("a"),
+		{Name: "b", Command: filepath.Join(t.TempDir(), "does-not-exist")},
+	}})
+
+	if err := sup.Run(context.Background()); err == nil {
+		t.Fatal("expected error for unstartable child, got nil")
+	}
+	if a := childNamed(sup, "a"); a.Running {
+		t.Errorf("a = %+v, want it stopped after the failed start", a)
+	}
+}
+
+func TestRunRejectsInvalidSpecs(t *testing.T) {
+	tests := []struct {
+		name     string
+		children []ChildSpec
+	}{
+		{"missing name", []ChildSpec{{Command: "x"}}},
+		{"missing command", []ChildSpec{{Name: "a"}}},
+		{"duplicate name", []ChildSpec{{Name: "a", Command: "x"}, {Name: "a", Command: "y"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := New(Config{Children: tt.children}).Run(context.Background()); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// bigdiff agent completes with a 200 KB summary - well past the old
+// 64 KiB line limit. With the prompt "chunked" it sends the complete
+// message as 16 KB chunks instead of one line.
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+	var task struct {
+		Prompt string `json:"prompt"`
+	}
+	json.Unmarshal(scanner.Bytes(), &task)
+
+	summary := strings.Repeat("+ a line of diff\n", 200<<10/17)
+	complete, _ := json.Marshal(map[string]any{
+		"type": "complete", "v": 1, "id": "test", "state": "done",
+		"summary": summary, "tokens_in": 1, "tokens_out": 1, "elapsed_s": 1,
+	})
+
+	if task.Prompt != "chunked" {
+		fmt.Printf("%s\n", complete)
+		return
+	}
+
+	const size = 16 << 10
+	for seq := 0; len(complete) > 0; seq++ {
+		n := min(size, len(complete))
+		chunk, _ := json.Marshal(map[string]any{
+			"type": "chunk", "v": 1, "id": "c1", "seq": seq,
+			"data": string(complete[:n]), "more": n < len(complete),
+		})
+		fmt.Printf("%s\n", chunk)
+		complete = complete[n:]
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// blocked agent asks a question, then goes silent until it gets an
+// answer. It reports the answer back as its summary.
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+
+	fmt.Println(`{"type":"blocked","v":1,"id":"test","question":"which approach?","options":["A","B","C"]}`)
+
+	// No heartbeats from here on - we're waiting for a human
+	for scanner.Scan() {
+		var msg struct {
+			Type     string `json:"type"`
+			Response string `json:"response"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "answer":
+			fmt.Printf(`{"type":"complete","v":1,"id":"test","state":"done","summary":%q,"tokens_in":0,"tokens_out":0,"elapsed_s":1}`+"\n", msg.Response)
+			return
+		case "cancel":
+			fmt.Println(`{"type":"complete","v":1,"id":"test","state":"cancelled","tokens_in":0,"tokens_out":0,"elapsed_s":1}`)
+			return
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
-import "os"
+import (
+	"fmt"
+	"os"
+)
 
 func main() {
-	// Don't even read stdin. Just die.
+	// Don't even read stdin. Just say why and die.
+	fmt.Fprintln(os.Stderr, "panic: agent fell over")
 	os.Exit(1)
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// echo agent does whatever its prompt says: "crash" exits 1, "fail"
+// completes with state failed, "linger" completes and then doesn't
+// exit, "write <file>" writes the task ID to
+// file ("write <file>, then hang" heartbeats forever after instead of
+// completing), and anything else just completes. Completions summarize the
+// prompt and the context the agent was given. Each takes a moment, so
+// tasks running in parallel overlap.
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1<<20)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+	var task struct {
+		ID      string `json:"id"`
+		Prompt  string `json:"prompt"`
+		Context string `json:"context"`
+	}
+	json.Unmarshal(scanner.Bytes(), &task)
+
+	time.Sleep(300 * time.Millisecond)
+
+	state, summary := "done", "did "+task.Prompt
+	switch task.Prompt {
+	case "crash":
+		fmt.Fprintln(os.Stderr, "crashing as asked")
+		os.Exit(1)
+	case "fail":
+		state = "failed"
+	}
+	if file, ok := strings.CutPrefix(task.Prompt, "write "); ok {
+		file, hang := strings.CutSuffix(file, ", then hang")
+		os.WriteFile(file, []byte(task.ID+"\n"), 0o644)
+		for hang {
+			fmt.Printf(`{"type":"heartbeat","v":1,"id":%q,"state":"running","tokens_in":1,"tokens_out":1,"elapsed_s":1}`+"\n", task.ID)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if task.Context != "" {
+		summary += ", knowing: " + task.Context
+	}
+	out, _ := json.Marshal(map[string]any{
+		"type": "complete", "v": 1, "id": task.ID, "state": state,
+		"summary": summary, "tokens_in": 1, "tokens_out": 1, "elapsed_s": 0.3,
+	})
+	fmt.Printf("%s\n", out)
+	if task.Prompt == "linger" {
+		time.Sleep(10 * time.Minute)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// future agent only speaks protocol versions that don't exist yet.
+func main() {
+	fmt.Println(`{"type":"hello","v":7,"versions":[7,8],"agent":"future/9.0"}`)
+
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+
+	fmt.Println(`{"type":"complete","v":7,"id":"test","state":"done","summary":"from the future","tokens_in":0,"tokens_out":0,"elapsed_s":1}`)
+}
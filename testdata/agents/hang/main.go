@@ -20,5 +20,7 @@ func main() {
 	fmt.Println(`{"type":"heartbeat","v":1,"id":"test","state":"running","tool":"bash","detail":"starting","rss_mb":10,"tokens_in":0,"tokens_out":0,"elapsed_s":0}`)
 
 	// Then go silent. Leopold's heartbeat timeout should kill the agent.
+	// Leave a last word on stderr for the timeout error.
+	fmt.Fprintln(os.Stderr, "waiting on something that will never happen")
 	time.Sleep(10 * time.Minute)
 }
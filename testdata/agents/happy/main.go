@@ -26,5 +26,5 @@ func main() {
 	fmt.Println(`{"type":"heartbeat","v":1,"id":"test","state":"running","tool":"file_write","detail":"writing code","rss_mb":12,"tokens_in":500,"tokens_out":200,"elapsed_s":2}`)
 
 	// Complete successfully
-	fmt.Println(`{"type":"complete","v":1,"id":"test","state":"done","summary":"task completed","files_changed":["main.go"],"tokens_in":1000,"tokens_out":400,"elapsed_s":3}`)
+	fmt.Println(`{"type":"complete","v":1,"id":"test","state":"done","summary":"task completed","files_changed":["main.go"],"tokens_in":1000,"tokens_out":400,"elapsed_s":3,"model":"fake-model","cost_usd":0.25}`)
 }
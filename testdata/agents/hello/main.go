@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// hello agent opens with a HelloMessage, then behaves like happy.
+func main() {
+	fmt.Println(`{"type":"hello","v":1,"versions":[1,2],"agent":"hello/1.0"}`)
+
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+
+	fmt.Println(`{"type":"heartbeat","v":1,"id":"test","state":"running","tool":"bash","detail":"working","rss_mb":10,"tokens_in":100,"tokens_out":50,"elapsed_s":1}`)
+	fmt.Println(`{"type":"complete","v":1,"id":"test","state":"done","summary":"task completed","tokens_in":1000,"tokens_out":400,"elapsed_s":3}`)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+)
+
+// liar agent allocates plenty of memory but swears in every heartbeat
+// that it's using almost none.
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+
+	var sink [][]byte
+	for i := 0; ; i++ {
+		// Allocate and touch 10MB per iteration so it's really resident
+		chunk := make([]byte, 10*1024*1024)
+		for j := range chunk {
+			chunk[j] = byte(j)
+		}
+		sink = append(sink, chunk)
+
+		fmt.Fprintf(os.Stdout, `{"type":"heartbeat","v":1,"id":"test","state":"running","tool":"bash","detail":"nothing to see here","rss_mb":1,"tokens_in":0,"tokens_out":0,"elapsed_s":%d}`+"\n", i)
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
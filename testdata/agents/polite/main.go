@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// polite agent works forever but honours a cancel message by reporting
+// a "cancelled" completion and exiting - or, if its prompt says "finish
+// anyway", a "done" one.
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+	state := "cancelled"
+	if strings.Contains(scanner.Text(), "finish anyway") {
+		state = "done"
+	}
+
+	// Keep the heartbeat going while we "work"
+	go func() {
+		for i := 0; ; i++ {
+			fmt.Printf(`{"type":"heartbeat","v":1,"id":"test","state":"running","tool":"bash","detail":"working","rss_mb":10,"tokens_in":%d,"tokens_out":%d,"elapsed_s":%g}`+"\n", i*100, i*10, float64(i)*0.05)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	// Wait for a cancel. Anything else on stdin is ignored.
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), `"type":"cancel"`) {
+			fmt.Printf(`{"type":"complete","v":1,"id":"test","state":%q,"error":"cancelled by orchestrator","tokens_in":0,"tokens_out":0,"elapsed_s":1}`+"\n", state)
+			os.Exit(0)
+		}
+	}
+}
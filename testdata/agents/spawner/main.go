@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// spawner agent starts a long-lived child process, the way a real agent
+// shells out to a compiler, and writes the child's PID to child.pid in
+// its working directory. With the prompt "hang" it then goes silent;
+// with "crash" the child inherits its stdout and the agent exits with
+// code 3; otherwise it completes and exits, leaving the child behind.
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "child" {
+		time.Sleep(10 * time.Minute)
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Read init message
+	scanner.Scan()
+
+	// Read task message
+	scanner.Scan()
+	var task struct {
+		Prompt string `json:"prompt"`
+	}
+	json.Unmarshal(scanner.Bytes(), &task)
+
+	child := exec.Command(os.Args[0], "child")
+	if task.Prompt == "crash" {
+		child.Stdout = os.Stdout
+	}
+	if err := child.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.WriteFile("child.pid", []byte(strconv.Itoa(child.Process.Pid)), 0o644)
+
+	fmt.Println(`{"type":"heartbeat","v":1,"id":"test","state":"running","tool":"bash","detail":"spawned","rss_mb":10,"tokens_in":0,"tokens_out":0,"elapsed_s":0}`)
+
+	switch task.Prompt {
+	case "hang":
+		time.Sleep(10 * time.Minute)
+	case "crash":
+		os.Exit(3)
+	}
+
+	fmt.Println(`{"type":"complete","v":1,"id":"test","state":"done","summary":"left a child behind","tokens_in":0,"tokens_out":0,"elapsed_s":1}`)
+}
@@ -0,0 +1,247 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backup is a Snapshot that can also put the directory back the way it
+// was with Restore.
+//
+// It keeps copies of the files the snapshot hashed: in a git work tree,
+// the dirty, untracked and ignored ones, plus the index and what HEAD
+// pointed at; clean files come back from HEAD. Anywhere else it copies
+// every file. Either way, mind the size of ignored trees like
+// node_modules and build outputs.
+type Backup struct {
+	*Snapshot
+
+	copies string // where the copies live
+	ref    string // the branch HEAD was on ("" if detached)
+	index  []byte // the git index (nil if there was none)
+}
+
+// Save backs up dir. Call Discard when done with the backup.
+func Save(ctx context.Context, dir string) (*Backup, error) {
+	s, err := Take(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backup{Snapshot: s}
+
+	if s.git {
+		if ref, err := runGit(ctx, dir, nil, "symbolic-ref", "--quiet", "HEAD"); err == nil {
+			b.ref = strings.TrimSpace(string(ref))
+		}
+		index, err := b.indexPath(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.index, err = os.ReadFile(index)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	b.copies, err = os.MkdirTemp("", "leopold-backup-")
+	if err != nil {
+		return nil, err
+	}
+	for path, h := range s.files {
+		if h == "" || h == "dir" {
+			continue
+		}
+		if err := copyFile(filepath.Join(dir, filepath.FromSlash(path)), filepath.Join(b.copies, filepath.FromSlash(path))); err != nil {
+			b.Discard()
+			return nil, fmt.Errorf("back up %s: %w", path, err)
+		}
+	}
+	return b, nil
+}
+
+// Restore puts the directory back as it was when backed up: created
+// files are removed, and modified and deleted ones brought back. In a
+// git work tree, HEAD and the index go back too, so commits made since
+// are dropped from the branch (though git keeps them around for a
+// while). It returns what it undid.
+func (b *Backup) Restore(ctx context.Context) (*Changes, error) {
+	changes, err := b.Changes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if b.git {
+		if err := b.restoreHead(ctx); err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, b.RestoreFiles(ctx, changes.All())
+}
+
+// RestoreFiles puts just the given files back as they were when backed
+// up, creating or removing them as needed. Unlike Restore it leaves git
+// alone: only the working tree changes, so any commits made since still
+// have the files as they were committed.
+func (b *Backup) RestoreFiles(ctx context.Context, paths []string) error {
+	for _, path := range paths {
+		dst := filepath.Join(b.Dir, filepath.FromSlash(path))
+		h, saved := b.files[path]
+		var err error
+		switch {
+		case saved && h == "dir":
+		case saved && h != "":
+			err = restoreFile(filepath.Join(b.copies, filepath.FromSlash(path)), dst)
+		case saved || !b.git:
+			// Wasn't there
+			err = removeFile(b.Dir, dst)
+		default:
+			// Clean at the time, so as HEAD had it, if it had it
+			if h, _ := b.hashAtHead(ctx, path); h == "" {
+				err = removeFile(b.Dir, dst)
+			} else {
+				_, err = runGit(ctx, b.Dir, nil, "restore", "--source="+b.head, "--worktree", "--", ":(literal)"+path)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// restoreHead puts HEAD, its branch and the index back.
+func (b *Backup) restoreHead(ctx context.Context) error {
+	ref := ""
+	if out, err := runGit(ctx, b.Dir, nil, "symbolic-ref", "--quiet", "HEAD"); err == nil {
+		ref = strings.TrimSpace(string(out))
+	}
+	if ref != b.ref {
+		var err error
+		if b.ref != "" {
+			_, err = runGit(ctx, b.Dir, nil, "symbolic-ref", "HEAD", b.ref)
+		} else {
+			_, err = runGit(ctx, b.Dir, nil, "update-ref", "--no-deref", "HEAD", b.head)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	head := ""
+	if out, err := runGit(ctx, b.Dir, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		head = strings.TrimSpace(string(out))
+	}
+	if head != b.head {
+		var err error
+		if b.head == "" {
+			// No commits back then: the branch didn't exist yet
+			_, err = runGit(ctx, b.Dir, nil, "update-ref", "-d", b.ref)
+		} else {
+			_, err = runGit(ctx, b.Dir, nil, "update-ref", "HEAD", b.head)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	index, err := b.indexPath(ctx)
+	if err != nil {
+		return err
+	}
+	if b.index == nil {
+		if err := os.Remove(index); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(index, b.index, 0o644)
+}
+
+// Discard removes the backup's copies. The directory itself is left as
+// it is.
+func (b *Backup) Discard() error {
+	if b.copies == "" {
+		return nil
+	}
+	return os.RemoveAll(b.copies)
+}
+
+func (b *Backup) indexPath(ctx context.Context) (string, error) {
+	out, err := runGit(ctx, b.Dir, nil, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	index := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(index) {
+		index = filepath.Join(b.Dir, index)
+	}
+	return index, nil
+}
+
+// copyFile copies a regular file or symlink, creating dst's parent
+// directories as needed.
+func copyFile(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// OpenFile's mode is subject to the umask, and ignored if dst existed
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// restoreFile replaces whatever is at dst with the backed-up src.
+func restoreFile(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+// removeFile removes path, then any directories above it, up to root,
+// that it leaves empty.
+func removeFile(root, path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			// Not empty, or not ours to remove
+			break
+		}
+	}
+	return nil
+}
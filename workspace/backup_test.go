@@ -0,0 +1,140 @@
+package workspace
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// contents reads every file under dir, skipping .git.
+func contents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRestoreWithoutGit(t *testing.T) {
+	dir := t.TempDir()
+	seed(t, dir)
+	want := contents(t, dir)
+	ctx := context.Background()
+
+	b, err := Save(ctx, dir)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	defer b.Discard()
+	mutate(t, dir)
+
+	undone, err := b.Restore(ctx)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	checkChanges(t, undone)
+	if got := contents(t, dir); !maps.Equal(got, want) {
+		t.Errorf("after restore: %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("sub/ survived the restore: %v", err)
+	}
+}
+
+func TestRestoreWithGit(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{})
+	ctx := context.Background()
+	seed(t, repo)
+	m.git(ctx, repo, "add", "-A")
+	m.git(ctx, repo, "commit", "-q", "-m", "seed")
+	head, _ := m.git(ctx, repo, "rev-parse", "HEAD")
+
+	// The user's own work in progress, staged and not
+	os.WriteFile(filepath.Join(repo, "keep.txt"), []byte("mine, staged"), 0o644)
+	m.git(ctx, repo, "add", "keep.txt")
+	os.WriteFile(filepath.Join(repo, "scratch.txt"), []byte("mine"), 0o644)
+	want := contents(t, repo)
+
+	b, err := Save(ctx, repo)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	defer b.Discard()
+
+	// The agent edits, commits some of it, and edits some more
+	mutate(t, repo)
+	os.WriteFile(filepath.Join(repo, "keep.txt"), []byte("agent"), 0o644)
+	m.git(ctx, repo, "add", "-A")
+	m.git(ctx, repo, "commit", "-q", "-m", "agent work")
+	os.WriteFile(filepath.Join(repo, "scratch.txt"), []byte("agent"), 0o644)
+
+	if _, err := b.Restore(ctx); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if got := contents(t, repo); !maps.Equal(got, want) {
+		t.Errorf("after restore: %v, want %v", got, want)
+	}
+	if now, _ := m.git(ctx, repo, "rev-parse", "HEAD"); now != head {
+		t.Errorf("HEAD = %s, want %s", now, head)
+	}
+	status, _ := m.git(ctx, repo, "status", "--porcelain")
+	if want := "M  keep.txt\n?? scratch.txt"; strings.TrimSpace(status) != want {
+		t.Errorf("status after restore:\n%s\nwant:\n%s", status, want)
+	}
+}
+
+func TestRestoreBringsBackIgnoredFiles(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{})
+	ctx := context.Background()
+	os.WriteFile(filepath.Join(repo, ".gitignore"), []byte(".env\n"), 0o644)
+	m.git(ctx, repo, "add", "-A")
+	m.git(ctx, repo, "commit", "-q", "-m", "ignore .env")
+	os.WriteFile(filepath.Join(repo, ".env"), []byte("SECRET=1"), 0o600)
+	want := contents(t, repo)
+
+	b, err := Save(ctx, repo)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	defer b.Discard()
+
+	// The agent overwrites it, then deletes it
+	os.WriteFile(filepath.Join(repo, ".env"), []byte("SECRET=2"), 0o600)
+	os.Remove(filepath.Join(repo, ".env"))
+
+	undone, err := b.Restore(ctx)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if !slices.Equal(undone.Deleted, []string{".env"}) {
+		t.Errorf("Deleted = %v, want [.env]", undone.Deleted)
+	}
+	if got := contents(t, repo); !maps.Equal(got, want) {
+		t.Errorf("after restore: %v, want %v", got, want)
+	}
+	if info, err := os.Stat(filepath.Join(repo, ".env")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf(".env after restore: %v, %v", info, err)
+	}
+}
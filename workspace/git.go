@@ -0,0 +1,56 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// git runs a git command in dir as the configured author.
+func (m *Manager) git(ctx context.Context, dir string, args ...string) (string, error) {
+	// Commits and merges are made on the agent's behalf, and shouldn't
+	// depend on (or fail for lack of) the user's git identity.
+	name, email := m.config.authorName(), m.config.authorEmail()
+	env := []string{
+		"GIT_AUTHOR_NAME=" + name, "GIT_AUTHOR_EMAIL=" + email,
+		"GIT_COMMITTER_NAME=" + name, "GIT_COMMITTER_EMAIL=" + email,
+	}
+	out, err := runGit(ctx, dir, env, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+// runGit runs a git command in dir and returns its stdout. On failure
+// the error includes what git printed, which is usually the only
+// useful part.
+func runGit(ctx context.Context, dir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// branchName turns a task ID into something git accepts as a branch
+// name component.
+func branchName(taskID string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, taskID)
+	if name == "" {
+		name = "task"
+	}
+	return name
+}
@@ -0,0 +1,273 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Snapshot records the files in a directory, so that what changed
+// since can be worked out with Changes.
+//
+// In a git work tree, the snapshot leans on git: it records HEAD and
+// hashes only the files git status reports - including ignored ones,
+// since .env files and build outputs are changes too - and asks git
+// what commits touched since. Anywhere else it hashes every file, skipping .git
+// directories. Either way, what counts is content: touching a file
+// without changing it isn't a change.
+type Snapshot struct {
+	Dir string
+
+	git    bool
+	prefix string            // Dir's path within the repository, e.g. "sub/"
+	head   string            // HEAD when taken ("" without commits)
+	files  map[string]string // path -> content hash; with git, only dirty, untracked and ignored files
+}
+
+// Changes is what happened to a directory's files between a snapshot
+// and now. Paths are slash-separated, relative to the directory, and
+// sorted.
+type Changes struct {
+	Created  []string
+	Modified []string
+	Deleted  []string
+}
+
+// All returns every changed path, sorted.
+func (c *Changes) All() []string {
+	all := slices.Concat(c.Created, c.Modified, c.Deleted)
+	slices.Sort(all)
+	return all
+}
+
+// Take snapshots dir.
+func Take(ctx context.Context, dir string) (*Snapshot, error) {
+	s := &Snapshot{Dir: dir}
+	if prefix, err := runGit(ctx, dir, nil, "rev-parse", "--show-prefix"); err == nil {
+		s.git = true
+		s.prefix = strings.TrimSpace(string(prefix))
+		if head, err := runGit(ctx, dir, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+			s.head = strings.TrimSpace(string(head))
+		}
+		dirty, err := s.dirty(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.files, err = s.hashAll(dirty)
+		return s, err
+	}
+
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		paths = append(paths, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.files, err = s.hashAll(paths)
+	return s, err
+}
+
+// Changes compares the directory as it is now against the snapshot.
+func (s *Snapshot) Changes(ctx context.Context) (*Changes, error) {
+	var paths []string
+	before := func(path string) (string, error) { return s.files[path], nil }
+
+	if s.git {
+		// Anything dirty then or now, or touched by a commit since
+		dirty, err := s.dirty(ctx)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(slices.Collect(maps.Keys(s.files)), dirty...)
+		if head, err := runGit(ctx, s.Dir, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+			if head := strings.TrimSpace(string(head)); head != s.head {
+				committed, err := s.committedSince(ctx, head)
+				if err != nil {
+					return nil, err
+				}
+				paths = append(paths, committed...)
+			}
+		}
+		// Clean files at snapshot time were as HEAD had them
+		before = func(path string) (string, error) {
+			if h, ok := s.files[path]; ok {
+				return h, nil
+			}
+			return s.hashAtHead(ctx, path)
+		}
+	} else {
+		now, err := Take(ctx, s.Dir)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(slices.Collect(maps.Keys(s.files)), slices.Collect(maps.Keys(now.files))...)
+	}
+
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+
+	c := &Changes{}
+	for _, path := range paths {
+		was, err := before(path)
+		if err != nil {
+			return nil, err
+		}
+		is, err := hashFile(filepath.Join(s.Dir, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case was == is:
+		case was == "":
+			c.Created = append(c.Created, path)
+		case is == "":
+			c.Deleted = append(c.Deleted, path)
+		default:
+			c.Modified = append(c.Modified, path)
+		}
+	}
+	return c, nil
+}
+
+// dirty lists the modified, deleted, untracked and ignored files git
+// knows about under Dir. Ignored files are listed one by one, not as
+// the directories that hold them.
+func (s *Snapshot) dirty(ctx context.Context) ([]string, error) {
+	out, err := runGit(ctx, s.Dir, nil, "status", "--porcelain=v1", "-z",
+		"--untracked-files=all", "--ignored=traditional", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	fields := bytes.Split(out, []byte{0})
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if len(f) < 4 {
+			continue
+		}
+		paths = append(paths, s.rel(string(f[3:])))
+		if f[0] == 'R' || f[0] == 'C' {
+			// Renames and copies carry the old path next
+			i++
+			if i < len(fields) {
+				paths = append(paths, s.rel(string(fields[i])))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// committedSince lists the files under Dir that differ between the
+// snapshot's HEAD and head.
+func (s *Snapshot) committedSince(ctx context.Context, head string) ([]string, error) {
+	var args []string
+	if s.head == "" {
+		// No commits at snapshot time: everything committed since is new
+		args = []string{"ls-tree", "-r", "--name-only", "-z", "--full-name", head}
+	} else {
+		args = []string{"diff", "--name-only", "-z", "--no-renames", s.head, head}
+	}
+	out, err := runGit(ctx, s.Dir, nil, append(args, "--", ".")...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, f := range bytes.Split(out, []byte{0}) {
+		if len(f) > 0 {
+			paths = append(paths, s.rel(string(f)))
+		}
+	}
+	return paths, nil
+}
+
+// hashAtHead hashes path as it was in the snapshot's HEAD, or returns
+// "" if it wasn't there.
+func (s *Snapshot) hashAtHead(ctx context.Context, path string) (string, error) {
+	if s.head == "" {
+		return "", nil
+	}
+	out, err := runGit(ctx, s.Dir, nil, "cat-file", "blob", s.head+":./"+path)
+	if err != nil {
+		// Not in that commit
+		return "", nil
+	}
+	return hashBytes(out), nil
+}
+
+// rel turns a path relative to the repository root into one relative
+// to Dir.
+func (s *Snapshot) rel(path string) string {
+	return strings.TrimPrefix(path, s.prefix)
+}
+
+func (s *Snapshot) hashAll(paths []string) (map[string]string, error) {
+	files := make(map[string]string, len(paths))
+	for _, path := range paths {
+		h, err := hashFile(filepath.Join(s.Dir, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, err
+		}
+		files[path] = h
+	}
+	return files, nil
+}
+
+// hashFile hashes a file's content, or a symlink's target. A missing
+// file hashes to "".
+func hashFile(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		// Same as git's blob for a symlink
+		return hashBytes([]byte(target)), nil
+	case info.IsDir():
+		// A submodule, or an empty directory git mentions
+		return "dir", nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
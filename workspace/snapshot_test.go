@@ -0,0 +1,147 @@
+package workspace
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// mutate makes the same set of changes in any directory that has
+// keep.txt, edit.txt and gone.txt.
+func mutate(t *testing.T, dir string) {
+	t.Helper()
+	os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("keep"), 0o644) // same content
+	os.WriteFile(filepath.Join(dir, "edit.txt"), []byte("edited"), 0o644)
+	os.Remove(filepath.Join(dir, "gone.txt"))
+	os.MkdirAll(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "sub", "new.txt"), []byte("new"), 0o644)
+}
+
+func seed(t *testing.T, dir string) {
+	t.Helper()
+	for _, f := range []string{"keep", "edit", "gone"} {
+		if err := os.WriteFile(filepath.Join(dir, f+".txt"), []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkChanges(t *testing.T, c *Changes) {
+	t.Helper()
+	if !slices.Equal(c.Created, []string{"sub/new.txt"}) {
+		t.Errorf("Created = %v, want [sub/new.txt]", c.Created)
+	}
+	if !slices.Equal(c.Modified, []string{"edit.txt"}) {
+		t.Errorf("Modified = %v, want [edit.txt]", c.Modified)
+	}
+	if !slices.Equal(c.Deleted, []string{"gone.txt"}) {
+		t.Errorf("Deleted = %v, want [gone.txt]", c.Deleted)
+	}
+}
+
+func TestSnapshotWithoutGit(t *testing.T) {
+	dir := t.TempDir()
+	seed(t, dir)
+	ctx := context.Background()
+
+	snap, err := Take(ctx, dir)
+	if err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	mutate(t, dir)
+	c, err := snap.Changes(ctx)
+	if err != nil {
+		t.Fatalf("changes: %v", err)
+	}
+	checkChanges(t, c)
+}
+
+func TestSnapshotWithGit(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{})
+	ctx := context.Background()
+	seed(t, repo)
+	os.WriteFile(filepath.Join(repo, ".gitignore"), []byte("*.env\nbuild/\n"), 0o644)
+	m.git(ctx, repo, "add", "-A")
+	m.git(ctx, repo, "commit", "-q", "-m", "seed")
+
+	// Already dirty before the task: must not be blamed on it
+	os.WriteFile(filepath.Join(repo, "scratch.txt"), []byte("mine"), 0o644)
+	// Ignored by git, but changes to them are changes all the same
+	os.WriteFile(filepath.Join(repo, "local.env"), []byte("SECRET=1"), 0o644)
+	os.MkdirAll(filepath.Join(repo, "build"), 0o755)
+	os.WriteFile(filepath.Join(repo, "build", "old.bin"), []byte("old"), 0o644)
+
+	snap, err := Take(ctx, repo)
+	if err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	mutate(t, repo)
+	os.WriteFile(filepath.Join(repo, "local.env"), []byte("SECRET=2"), 0o644)
+	os.Remove(filepath.Join(repo, "build", "old.bin"))
+	os.WriteFile(filepath.Join(repo, "build", "new.bin"), []byte("new"), 0o644)
+
+	c, err := snap.Changes(ctx)
+	if err != nil {
+		t.Fatalf("changes: %v", err)
+	}
+	if want := []string{"build/new.bin", "sub/new.txt"}; !slices.Equal(c.Created, want) {
+		t.Errorf("Created = %v, want %v", c.Created, want)
+	}
+	if want := []string{"edit.txt", "local.env"}; !slices.Equal(c.Modified, want) {
+		t.Errorf("Modified = %v, want %v", c.Modified, want)
+	}
+	if want := []string{"build/old.bin", "gone.txt"}; !slices.Equal(c.Deleted, want) {
+		t.Errorf("Deleted = %v, want %v", c.Deleted, want)
+	}
+}
+
+func TestSnapshotSeesCommittedChanges(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{})
+	ctx := context.Background()
+	seed(t, repo)
+	m.git(ctx, repo, "add", "-A")
+	m.git(ctx, repo, "commit", "-q", "-m", "seed")
+
+	snap, err := Take(ctx, repo)
+	if err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	// The agent commits its own work, leaving a clean tree
+	mutate(t, repo)
+	m.git(ctx, repo, "add", "-A")
+	m.git(ctx, repo, "commit", "-q", "-m", "agent work")
+
+	c, err := snap.Changes(ctx)
+	if err != nil {
+		t.Fatalf("changes: %v", err)
+	}
+	checkChanges(t, c)
+}
+
+func TestSnapshotOfSubdirectory(t *testing.T) {
+	repo := newRepo(t)
+	ctx := context.Background()
+	dir := filepath.Join(repo, "pkg")
+	os.MkdirAll(dir, 0o755)
+	seed(t, dir)
+	m := New(Config{})
+	m.git(ctx, repo, "add", "-A")
+	m.git(ctx, repo, "commit", "-q", "-m", "seed")
+
+	snap, err := Take(ctx, dir)
+	if err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	mutate(t, dir)
+	os.WriteFile(filepath.Join(repo, "outside.txt"), []byte("x"), 0o644)
+
+	c, err := snap.Changes(ctx)
+	if err != nil {
+		t.Fatalf("changes: %v", err)
+	}
+	checkChanges(t, c)
+}
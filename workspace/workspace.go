@@ -0,0 +1,274 @@
+// Package workspace gives every agent task its own git worktree, so
+// tasks running in parallel on the same repository don't trample each
+// other's files.
+//
+// Each task works on a fresh branch checked out in a worktree outside
+// the repository. When it's over, the agent's changes are committed on
+// that branch, then merged back or kept for review; a failed task's
+// worktree and branch are thrown away. The worktree itself is always
+// removed.
+//
+// Separately, a Snapshot records a directory's files so that what an
+// agent actually changed can be checked against what it claims.
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// OnSuccess is what happens to a successful task's branch.
+type OnSuccess int
+
+const (
+	// Keep leaves the branch in the repository for review.
+	Keep OnSuccess = iota
+	// Merge merges the branch into whatever the repository has checked
+	// out, then deletes it. A merge that doesn't go cleanly is aborted
+	// and the branch kept instead.
+	Merge
+)
+
+func (o OnSuccess) String() string {
+	switch o {
+	case Keep:
+		return "keep"
+	case Merge:
+		return "merge"
+	default:
+		return fmt.Sprintf("OnSuccess(%d)", int(o))
+	}
+}
+
+// ErrMergeConflict is returned by Finish when a successful task's
+// branch couldn't be merged. The branch is kept.
+var ErrMergeConflict = errors.New("merge failed")
+
+// Config describes where worktrees go and what happens to them.
+type Config struct {
+	Root         string    // directory to create worktrees in ("" = a "leopold-worktrees" dir under os.TempDir)
+	BranchPrefix string    // prepended to each task's branch name ("" = DefaultBranchPrefix)
+	OnSuccess    OnSuccess // what to do with a successful task's branch
+	Author       string    // name on commits made for the agent ("" = DefaultAuthor)
+	AuthorEmail  string    // email on those commits ("" = DefaultAuthorEmail)
+}
+
+// Defaults for Config.
+const (
+	DefaultBranchPrefix = "leopold/"
+	DefaultAuthor       = "Leopold"
+	DefaultAuthorEmail  = "leopold@localhost"
+)
+
+func (c Config) authorName() string {
+	if c.Author == "" {
+		return DefaultAuthor
+	}
+	return c.Author
+}
+
+func (c Config) authorEmail() string {
+	if c.AuthorEmail == "" {
+		return DefaultAuthorEmail
+	}
+	return c.AuthorEmail
+}
+
+// Manager creates and finishes workspaces. It's safe for concurrent
+// use; operations that touch the repository itself are serialized.
+type Manager struct {
+	config Config
+	mu     sync.Mutex
+}
+
+// New returns a Manager for cfg.
+func New(cfg Config) *Manager {
+	if cfg.Root == "" {
+		cfg.Root = filepath.Join(os.TempDir(), "leopold-worktrees")
+	}
+	if cfg.BranchPrefix == "" {
+		cfg.BranchPrefix = DefaultBranchPrefix
+	}
+	return &Manager{config: cfg}
+}
+
+// Workspace is one task's worktree.
+type Workspace struct {
+	TaskID string
+	Repo   string // the repository the worktree belongs to
+	Prefix string // the directory Create was given, relative to Repo ("" = Repo itself)
+	Dir    string // the worktree; the agent works in Prefix under it
+	Branch string
+	Base   string // the commit the branch started from
+}
+
+// Outcome is what Finish did with a workspace.
+type Outcome struct {
+	Branch string
+	Commit string // the commit with the agent's changes ("" if it changed nothing)
+	Merged bool   // merged into the repository and the branch deleted
+	Kept   bool   // branch left in the repository
+
+	// Worktree is set if the worktree was left in place: the task
+	// succeeded but its changes couldn't be committed.
+	Worktree string
+}
+
+// Create makes a worktree for taskID on a new branch off repo's HEAD.
+// repo may be a subdirectory of a repository, in which case Prefix
+// says which one, and the agent belongs in the same subdirectory of
+// the worktree.
+// If the task ID's branch already exists - a kept branch from an
+// earlier run - a numeric suffix is added.
+func (m *Manager) Create(ctx context.Context, repo, taskID string) (*Workspace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix, err := m.git(ctx, repo, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	prefix = filepath.FromSlash(strings.TrimSuffix(prefix, "/"))
+	repo, err = m.git(ctx, repo, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	base, err := m.git(ctx, repo, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(m.config.Root, 0o755); err != nil {
+		return nil, fmt.Errorf("create worktree root: %w", err)
+	}
+	// Forget worktrees whose directories are gone, say after a crash
+	m.git(ctx, repo, "worktree", "prune")
+
+	branch := m.config.BranchPrefix + branchName(taskID)
+	for i := 2; m.branchExists(ctx, repo, branch); i++ {
+		branch = fmt.Sprintf("%s%s-%d", m.config.BranchPrefix, branchName(taskID), i)
+	}
+	dir, err := os.MkdirTemp(m.config.Root, branchName(taskID)+"-")
+	if err != nil {
+		return nil, fmt.Errorf("create worktree dir: %w", err)
+	}
+	if _, err := m.git(ctx, repo, "worktree", "add", "-b", branch, dir, base); err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	// The subdirectory may hold nothing git tracks
+	if err := os.MkdirAll(filepath.Join(dir, prefix), 0o755); err != nil {
+		m.remove(ctx, &Workspace{Repo: repo, Dir: dir})
+		m.git(ctx, repo, "branch", "-D", branch)
+		return nil, fmt.Errorf("create worktree subdirectory: %w", err)
+	}
+	return &Workspace{TaskID: taskID, Repo: repo, Prefix: prefix, Dir: dir, Branch: branch, Base: base}, nil
+}
+
+// Finish ends a workspace. If the task succeeded, whatever the agent
+// left in the worktree is committed to its branch, which is then kept
+// or merged according to Config.OnSuccess. If it failed, the branch is
+// deleted. The worktree is removed either way, so crashed and killed
+// agents never leave worktrees behind; the one exception is finished
+// work that couldn't be committed, which is left where it is rather
+// than lost.
+func (m *Manager) Finish(ctx context.Context, ws *Workspace, succeeded bool) (*Outcome, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := &Outcome{Branch: ws.Branch}
+	if succeeded {
+		commit, err := m.commit(ctx, ws)
+		if err != nil {
+			out.Kept, out.Worktree = true, ws.Dir
+			return out, fmt.Errorf("commit task %s: %w", ws.TaskID, err)
+		}
+		out.Commit = commit
+	}
+
+	// The worktree goes first: git won't delete or merge a branch
+	// that's checked out somewhere.
+	rmErr := m.remove(ctx, ws)
+
+	switch {
+	case !succeeded:
+		_, err := m.git(ctx, ws.Repo, "branch", "-D", ws.Branch)
+		return out, errors.Join(rmErr, err)
+	case rmErr != nil:
+		out.Kept = true
+		return out, rmErr
+	case out.Commit == "":
+		// Nothing to review or merge
+		_, err := m.git(ctx, ws.Repo, "branch", "-D", ws.Branch)
+		return out, err
+	case m.config.OnSuccess == Merge:
+		if err := m.merge(ctx, ws); err != nil {
+			out.Kept = true
+			return out, err
+		}
+		out.Merged = true
+		return out, nil
+	default:
+		out.Kept = true
+		return out, nil
+	}
+}
+
+// commit records everything in the worktree on the task's branch. The
+// agent may have committed some or all of it itself; the returned
+// commit is the branch's tip if it moved at all, and "" if not.
+func (m *Manager) commit(ctx context.Context, ws *Workspace) (string, error) {
+	if _, err := m.git(ctx, ws.Dir, "add", "-A"); err != nil {
+		return "", err
+	}
+	status, err := m.git(ctx, ws.Dir, "status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	if status != "" {
+		msg := fmt.Sprintf("leopold: task %s", ws.TaskID)
+		if _, err := m.git(ctx, ws.Dir, "commit", "--no-verify", "-m", msg); err != nil {
+			return "", err
+		}
+	}
+	head, err := m.git(ctx, ws.Dir, "rev-parse", "HEAD")
+	if err != nil || head == ws.Base {
+		return "", err
+	}
+	return head, nil
+}
+
+// merge merges the task's branch into the repository's checkout and
+// deletes it, or aborts and leaves everything as it was.
+func (m *Manager) merge(ctx context.Context, ws *Workspace) error {
+	msg := fmt.Sprintf("Merge task %s", ws.TaskID)
+	if _, err := m.git(ctx, ws.Repo, "merge", "--no-edit", "-m", msg, ws.Branch); err != nil {
+		m.git(ctx, ws.Repo, "merge", "--abort")
+		return fmt.Errorf("%w: branch %s kept: %w", ErrMergeConflict, ws.Branch, err)
+	}
+	_, err := m.git(ctx, ws.Repo, "branch", "-d", ws.Branch)
+	return err
+}
+
+// remove deletes the worktree, including anything the agent left in
+// it, and makes sure the directory is gone even if git balks.
+func (m *Manager) remove(ctx context.Context, ws *Workspace) error {
+	_, err := m.git(ctx, ws.Repo, "worktree", "remove", "--force", ws.Dir)
+	if rmErr := os.RemoveAll(ws.Dir); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	if err != nil {
+		m.git(ctx, ws.Repo, "worktree", "prune")
+	}
+	return err
+}
+
+// branchExists reports whether repo has a local branch by that name.
+func (m *Manager) branchExists(ctx context.Context, repo, branch string) bool {
+	_, err := m.git(ctx, repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	return err == nil
+}
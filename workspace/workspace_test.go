@@ -0,0 +1,205 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRepo creates a git repository with one commit.
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	m := New(Config{})
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		if _, err := m.git(context.Background(), repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// branches lists repo's local branches.
+func branches(t *testing.T, repo string) []string {
+	t.Helper()
+	out, err := New(Config{}).git(context.Background(), repo, "branch", "--format=%(refname:short)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(out)
+}
+
+func TestCreateMakesIsolatedWorktree(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{Root: t.TempDir()})
+	ctx := context.Background()
+
+	a, err := m.Create(ctx, repo, "task/1")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b, err := m.Create(ctx, repo, "task/2")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if a.Dir == b.Dir || a.Branch == b.Branch {
+		t.Fatalf("workspaces share a dir or branch: %+v, %+v", a, b)
+	}
+	if a.Branch != "leopold/task-1" {
+		t.Errorf("branch = %q, want %q", a.Branch, "leopold/task-1")
+	}
+	os.WriteFile(filepath.Join(a.Dir, "a.txt"), []byte("a"), 0o644)
+	if _, err := os.Stat(filepath.Join(b.Dir, "a.txt")); err == nil {
+		t.Error("file written in one worktree showed up in another")
+	}
+	if _, err := os.Stat(filepath.Join(repo, "a.txt")); err == nil {
+		t.Error("file written in a worktree showed up in the repo")
+	}
+}
+
+func TestCreateKeepsSubdirectory(t *testing.T) {
+	repo := newRepo(t)
+	sub := filepath.Join(repo, "sub", "dir")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := New(Config{Root: t.TempDir()})
+
+	ws, err := m.Create(context.Background(), sub, "task")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if want := filepath.Join("sub", "dir"); ws.Prefix != want {
+		t.Errorf("prefix = %q, want %q", ws.Prefix, want)
+	}
+	if top, _ := filepath.EvalSymlinks(repo); ws.Repo != top {
+		t.Errorf("repo = %q, want the top level %q", ws.Repo, top)
+	}
+	// Nothing in sub/dir is tracked, but the agent still needs it
+	if fi, err := os.Stat(filepath.Join(ws.Dir, ws.Prefix)); err != nil || !fi.IsDir() {
+		t.Errorf("subdirectory missing from the worktree: %v", err)
+	}
+}
+
+func TestFinishKeepsSuccessfulBranch(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{Root: t.TempDir()})
+	ctx := context.Background()
+
+	ws, _ := m.Create(ctx, repo, "t1")
+	os.WriteFile(filepath.Join(ws.Dir, "out.txt"), []byte("done"), 0o644)
+
+	out, err := m.Finish(ctx, ws, true)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if !out.Kept || out.Merged || out.Commit == "" {
+		t.Errorf("outcome = %+v, want a kept branch with a commit", out)
+	}
+	if _, err := os.Stat(ws.Dir); !os.IsNotExist(err) {
+		t.Errorf("worktree still exists: %v", err)
+	}
+	if got, _ := m.git(ctx, repo, "show", ws.Branch+":out.txt"); got != "done" {
+		t.Errorf("branch has out.txt = %q, want %q", got, "done")
+	}
+
+	// Running the same task again gets a branch of its own
+	again, err := m.Create(ctx, repo, "t1")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if again.Branch != "leopold/t1-2" {
+		t.Errorf("branch = %q, want %q", again.Branch, "leopold/t1-2")
+	}
+}
+
+func TestFinishMergesSuccessfulBranch(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{Root: t.TempDir(), OnSuccess: Merge})
+	ctx := context.Background()
+
+	a, _ := m.Create(ctx, repo, "a")
+	b, _ := m.Create(ctx, repo, "b")
+	os.WriteFile(filepath.Join(a.Dir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(b.Dir, "b.txt"), []byte("b"), 0o644)
+
+	for _, ws := range []*Workspace{a, b} {
+		out, err := m.Finish(ctx, ws, true)
+		if err != nil {
+			t.Fatalf("finish %s: %v", ws.TaskID, err)
+		}
+		if !out.Merged || out.Kept {
+			t.Errorf("outcome = %+v, want merged", out)
+		}
+	}
+	for _, f := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(repo, f)); err != nil {
+			t.Errorf("%s not merged: %v", f, err)
+		}
+	}
+	if got := branches(t, repo); len(got) != 1 || got[0] != "main" {
+		t.Errorf("branches = %v, want only main", got)
+	}
+}
+
+func TestFinishKeepsBranchOnMergeConflict(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{Root: t.TempDir(), OnSuccess: Merge})
+	ctx := context.Background()
+
+	a, _ := m.Create(ctx, repo, "a")
+	b, _ := m.Create(ctx, repo, "b")
+	os.WriteFile(filepath.Join(a.Dir, "same.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(b.Dir, "same.txt"), []byte("b"), 0o644)
+
+	if _, err := m.Finish(ctx, a, true); err != nil {
+		t.Fatalf("finish a: %v", err)
+	}
+	out, err := m.Finish(ctx, b, true)
+	if !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("err = %v, want ErrMergeConflict", err)
+	}
+	if !out.Kept || out.Merged {
+		t.Errorf("outcome = %+v, want the branch kept", out)
+	}
+	if status, _ := m.git(ctx, repo, "status", "--porcelain"); status != "" {
+		t.Errorf("repo left mid-merge:\n%s", status)
+	}
+}
+
+func TestFinishDiscardsFailedTask(t *testing.T) {
+	repo := newRepo(t)
+	m := New(Config{Root: t.TempDir(), OnSuccess: Merge})
+	ctx := context.Background()
+
+	ws, _ := m.Create(ctx, repo, "t1")
+	os.WriteFile(filepath.Join(ws.Dir, "half-done.txt"), []byte("oops"), 0o644)
+
+	out, err := m.Finish(ctx, ws, false)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if out.Kept || out.Merged || out.Commit != "" {
+		t.Errorf("outcome = %+v, want nothing kept", out)
+	}
+	if _, err := os.Stat(ws.Dir); !os.IsNotExist(err) {
+		t.Errorf("worktree still exists: %v", err)
+	}
+	if got := branches(t, repo); len(got) != 1 {
+		t.Errorf("branches = %v, want only main", got)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "half-done.txt")); err == nil {
+		t.Error("failed task's file ended up in the repo")
+	}
+}